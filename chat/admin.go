@@ -0,0 +1,60 @@
+package chat
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin guards a handler with the admin bearer token.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.AdminToken == "" {
+			http.Error(w, "admin endpoints are disabled", http.StatusForbidden)
+			return
+		}
+		if !isAdmin(s.cfg.AdminToken, r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isAdmin reports whether a request carries the admin token, for endpoints
+// that show admins more rather than being admin-only. No request does when
+// token is empty.
+func isAdmin(token string, r *http.Request) bool {
+	if token == "" {
+		return false
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// serveDrain switches drain mode on or off. While draining, serveWS turns
+// away new connections but existing sessions carry on, so a load balancer can
+// move traffic elsewhere before the process is stopped.
+func serveDrain(hub *Hub, audit *auditLog, drain bool, w http.ResponseWriter, r *http.Request) {
+	if hub.draining.Swap(drain) == drain {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	action := "undrain"
+	if drain {
+		action = "drain"
+	}
+	audit.record(r, action, "")
+
+	if drain {
+		log.Println("Draining: rejecting new connections")
+		notice := systemMessage(typeSystem, "This server is draining for maintenance. Please reconnect to continue chatting.")
+		notice.Priority = true
+		hub.announce(notice)
+	} else {
+		log.Println("Drain cancelled: accepting new connections")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,285 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxPostBodyBytes = 64 << 10
+
+// flexTime accepts an RFC 3339 string or a Unix epoch number (seconds, or
+// milliseconds for values too large to be seconds). Anything else, including
+// a missing field, leaves it zero so the server's clock is used instead.
+type flexTime struct {
+	time.Time
+}
+
+func (t *flexTime) UnmarshalJSON(data []byte) error {
+	t.Time = time.Time{}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+
+	if data[0] == '"' {
+		var s string
+		if json.Unmarshal(data, &s) != nil {
+			return nil
+		}
+		if parsed, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			t.Time = parsed
+		}
+		return nil
+	}
+
+	n, err := strconv.ParseFloat(string(data), 64)
+	if err != nil || n <= 0 || math.IsInf(n, 0) {
+		return nil
+	}
+	if n > 1e12 {
+		n /= 1000
+	}
+	sec, frac := math.Modf(n)
+	t.Time = time.Unix(int64(sec), int64(frac*1e9))
+	return nil
+}
+
+// postRequest is the body of POST /api/messages, used by integrations to
+// post into a room or import history.
+type postRequest struct {
+	Room      string   `json:"room"`
+	Username  string   `json:"username"`
+	Content   string   `json:"content"`
+	Platform  string   `json:"platform"`
+	Format    string   `json:"format"`
+	Timestamp flexTime `json:"timestamp"`
+	// Signature is a registered bot's base64 Ed25519 signature; see
+	// signedPayload.
+	Signature string `json:"signature"`
+}
+
+// servePostMessage injects a message into a room as if it had been sent over
+// a WebSocket. Unlike live chat, the caller's timestamp is kept when it
+// parses, which is what history imports need.
+func servePostMessage(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	msg, ok := readPost(hub, w, r)
+	if !ok {
+		return
+	}
+	if err := checkPost(hub, &msg); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	hub.metrics.countMessage(msg)
+
+	msg.received = time.Now()
+	select {
+	case hub.broadcast <- msg:
+	case <-hub.done:
+		http.Error(w, "hub stopped", http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(msg)
+}
+
+// serveValidateMessage is a dry run of servePostMessage for integration
+// developers: it answers with the message that would have been broadcast,
+// mentions resolved, or the same error a real post would get.
+func serveValidateMessage(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	msg, ok := readPost(hub, w, r)
+	if !ok {
+		return
+	}
+	if err := checkPost(hub, &msg); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msg)
+}
+
+// checkPost is everything a posted message must pass besides readPost's
+// checks, shared so that validating a message answers exactly as posting it
+// would. It also fills in the message's mentions. Slow mode and the room
+// rate are left to Run(), since checking them uses them up.
+func checkPost(hub *Hub, msg *Message) error {
+	var err error
+	if !hub.do(func() {
+		if !hub.archived[msg.Room].IsZero() {
+			err = roomArchivedError(msg.Room)
+		} else {
+			err = hub.checkRoomFormat(*msg)
+		}
+		msg.Mentions = hub.resolveMentions(msg.Content)
+	}) {
+		return errShuttingDown
+	}
+	if err == nil && hub.overloaded() {
+		err = serverBusyError()
+	}
+	return err
+}
+
+// readPost decodes, normalizes and validates a posted message, writing the
+// error response and returning false if it is rejected.
+func readPost(hub *Hub, w http.ResponseWriter, r *http.Request) (Message, bool) {
+	var req postRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPostBodyBytes)).Decode(&req); err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			http.Error(w, "request body too large", httpStatus(errMessageTooLong))
+			return Message{}, false
+		}
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return Message{}, false
+	}
+	if req.Room == "" {
+		req.Room = defaultRoom
+	}
+	if !validRoomName(req.Room) {
+		http.Error(w, "invalid room name", http.StatusBadRequest)
+		return Message{}, false
+	}
+	verified, err := verifyBot(hub.botKeys, req.Username, req.Room, req.Content, req.Signature)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return Message{}, false
+	}
+	room, _, err := hub.resolveRoom(req.Room)
+	if err != nil {
+		http.Error(w, "no such room #"+req.Room, httpStatus(err))
+		return Message{}, false
+	}
+	req.Room = room
+	if req.Username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return Message{}, false
+	}
+
+	msg := Message{
+		ID:        newID(),
+		Type:      typeChat,
+		Username:  req.Username,
+		Room:      req.Room,
+		Content:   hub.normalizeContent(req.Content),
+		Platform:  normalizePlatform(req.Platform),
+		Format:    req.Format,
+		Verified:  verified,
+		Timestamp: req.Timestamp.Time,
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	if err := hub.validate(msg); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return Message{}, false
+	}
+	hub.applyFormat(&msg)
+	if err := hub.checkMetadataSize(msg); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return Message{}, false
+	}
+	return msg, true
+}
+
+// checkAPIToken requires a valid bearer token on read endpoints when token
+// auth is on, and writes the error response if it's missing.
+func checkAPIToken(auth *tokenAuth, w http.ResponseWriter, r *http.Request) bool {
+	if auth == nil {
+		return true
+	}
+	raw := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if _, err := auth.username(raw); err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// apiUsername is who a REST caller is, found the way serveWS finds a
+// WebSocket client's name: from the bearer token with token auth, from
+// the proxy's header with -username-header, and otherwise from the
+// username query parameter, which the caller picks itself.
+func apiUsername(hub *Hub, auth *tokenAuth, r *http.Request) (string, error) {
+	switch {
+	case auth != nil:
+		return auth.username(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	case hub.cfg.UsernameHeader != "":
+		return headerUsername(hub.cfg.UsernameHeader, hub.cfg.TrustedProxies, r)
+	}
+	return r.URL.Query().Get("username"), nil
+}
+
+// checkRoomRead reports whether the caller may read a room's messages,
+// answering the request if not. Admins may read any room; everyone else
+// only the rooms they could join, which matters most for encrypted rooms,
+// whose history is returned decrypted.
+func checkRoomRead(hub *Hub, auth *tokenAuth, room string, w http.ResponseWriter, r *http.Request) bool {
+	if isAdmin(hub.cfg.AdminToken, r) {
+		return true
+	}
+	username, err := apiUsername(hub, auth, r)
+	if err != nil {
+		if auth != nil {
+			err = errors.New("invalid token")
+		}
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if !hub.authorizeJoin(username, room, r) {
+		err := fmt.Errorf("%w: #%s", errRoomForbidden, room)
+		http.Error(w, err.Error(), httpStatus(err))
+		return false
+	}
+	return true
+}
+
+// serveHistory returns a room's recent messages, oldest first, e.g.
+// GET /api/messages?room=general. The caller must be allowed into the room
+// just as a WebSocket client would, which with token auth means a valid
+// bearer token.
+func serveHistory(hub *Hub, auth *tokenAuth, w http.ResponseWriter, r *http.Request) {
+	room := r.URL.Query().Get("room")
+	if room == "" {
+		room = defaultRoom
+	}
+	if !validRoomName(room) {
+		http.Error(w, "invalid room name", http.StatusBadRequest)
+		return
+	}
+	if !checkRoomRead(hub, auth, room, w, r) {
+		return
+	}
+
+	var messages []Message
+	var err error
+	if !hub.readStore(func(s MessageStore) { messages, err = s.Recent(room) }) {
+		http.Error(w, "hub stopped", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("History for #%s unavailable: %v", room, err)
+		http.Error(w, "history unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if messages == nil {
+		messages = []Message{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// post sends an admin request to the test server.
+func (ts *testServer) post(t *testing.T, path, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest("POST", ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+ts.srv.cfg.AdminToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// TestPostAndValidateAgree checks that validating a message answers the
+// way posting it does.
+func TestPostAndValidateAgree(t *testing.T) {
+	tests := []struct {
+		name  string
+		room  string
+		setup func(h *Hub)
+		want  int
+	}{
+		{"accepted", "general", func(h *Hub) {}, 0},
+		{"archived room", "old", func(h *Hub) {
+			h.do(func() { h.archived["old"] = time.Now() })
+		}, http.StatusConflict},
+		{"wrong format", "links", func(h *Hub) {}, http.StatusUnprocessableEntity},
+		{"shedding load", "general", func(h *Hub) {
+			// Nothing is queued, so hold shedding on by hand.
+			h.shedResume = -1
+			h.shedding.Store(true)
+		}, http.StatusServiceUnavailable},
+	}
+	endpoints := []struct {
+		path string
+		ok   int
+	}{
+		{"/api/messages", http.StatusAccepted},
+		{"/api/messages/validate", http.StatusOK},
+	}
+	for _, tt := range tests {
+		for _, e := range endpoints {
+			t.Run(tt.name+e.path, func(t *testing.T) {
+				cfg := DefaultConfig()
+				cfg.AdminToken = "secret"
+				cfg.Rooms["old"] = true
+				cfg.Rooms["links"] = true
+				cfg.RoomFormats["links"], _ = ParseContentRule("link")
+				ts := startServer(t, cfg)
+				tt.setup(ts.srv.hub)
+
+				resp := ts.post(t, e.path, `{"room":"`+tt.room+`","username":"bot","content":"hello"}`)
+				want := tt.want
+				if want == 0 {
+					want = e.ok
+				}
+				if resp.StatusCode != want {
+					t.Errorf("status = %d, want %d", resp.StatusCode, want)
+				}
+			})
+		}
+	}
+}
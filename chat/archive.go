@@ -0,0 +1,124 @@
+package chat
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+func roomArchivedError(room string) error {
+	return wrapf(errRoomArchived, "#%s is archived and read-only", room)
+}
+
+// archiveIdleRooms archives rooms that have been quiet for the archive
+// period. The default room is never archived. It must only be called from
+// Run().
+func (h *Hub) archiveIdleRooms(now time.Time) {
+	if h.archiveAfter <= 0 {
+		return
+	}
+	for _, r := range h.rooms {
+		if r.name != defaultRoom && h.archived[r.name].IsZero() && now.Sub(r.lastActive) > h.archiveAfter {
+			h.setArchived(r.name, true, "idle")
+		}
+	}
+}
+
+// setArchived archives or unarchives a room, telling anyone in it, and
+// reports whether anything changed. A room that isn't loaded stays that way.
+// It must only be called from Run().
+func (h *Hub) setArchived(name string, archived bool, reason string) bool {
+	if !h.archived[name].IsZero() == archived {
+		return false
+	}
+	r, loaded := h.rooms[name]
+	if archived {
+		h.archived[name] = time.Now()
+		log.Printf("Archived room #%s (%s)", name, reason)
+		if loaded {
+			h.deliver(r, systemMessage(typeSystem, "#%s has been archived: you can still read it, but not post", name))
+		} else {
+			h.pruneArchived()
+		}
+	} else {
+		delete(h.archived, name)
+		log.Printf("Unarchived room #%s (%s)", name, reason)
+		if loaded {
+			// Start the archive period afresh.
+			r.touch()
+			h.deliver(r, systemMessage(typeSystem, "#%s is open for messages again", name))
+		}
+	}
+	return true
+}
+
+// knownRoom reports whether a room is loaded, configured or archived. It
+// must only be called from Run().
+func (h *Hub) knownRoom(name string) bool {
+	_, loaded := h.rooms[name]
+	return loaded || h.permanentRoom(name) || !h.archived[name].IsZero()
+}
+
+// serveArchive archives or unarchives a room by hand. Rooms the hub doesn't
+// know and that have no stored history are not found, rather than created.
+func serveArchive(hub *Hub, audit *auditLog, archived bool, w http.ResponseWriter, r *http.Request) {
+	room := r.PathValue("room")
+	if !validRoomName(room) {
+		http.Error(w, "invalid room name", http.StatusBadRequest)
+		return
+	}
+	var stored bool
+	if !hub.readStore(func(s MessageStore) {
+		history, err := s.Recent(room)
+		stored = err == nil && len(history) > 0
+	}) {
+		http.Error(w, "hub stopped", http.StatusServiceUnavailable)
+		return
+	}
+	var known, changed bool
+	if !hub.do(func() {
+		if known = stored || hub.knownRoom(room); known {
+			changed = hub.setArchived(room, archived, "admin")
+		}
+	}) {
+		http.Error(w, "hub stopped", http.StatusServiceUnavailable)
+		return
+	}
+	if !known {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return
+	}
+	if changed {
+		action := "unarchive"
+		if archived {
+			action = "archive"
+		}
+		audit.record(r, action, "#"+room)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pruneArchived forgets the longest archived rooms that are no longer in
+// memory until at most maxArchived of those are left. It must only be
+// called from Run().
+func (h *Hub) pruneArchived() {
+	if h.maxArchived <= 0 {
+		return
+	}
+	var evicted []string
+	for name := range h.archived {
+		if _, loaded := h.rooms[name]; !loaded && !h.permanentRoom(name) {
+			evicted = append(evicted, name)
+		}
+	}
+	if len(evicted) <= h.maxArchived {
+		return
+	}
+	slices.SortFunc(evicted, func(a, b string) int { return h.archived[a].Compare(h.archived[b]) })
+	for _, name := range evicted[:len(evicted)-h.maxArchived] {
+		delete(h.archived, name)
+		h.forgetRoom(name)
+		log.Printf("Forgot archived room #%s: over -max-archived-rooms (%d)", name, h.maxArchived)
+	}
+}
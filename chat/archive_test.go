@@ -0,0 +1,87 @@
+package chat
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestArchiveUnknownRoom checks that the admin archive endpoints only act on
+// rooms that exist, and never load a room to do it.
+func TestArchiveUnknownRoom(t *testing.T) {
+	tests := []struct {
+		name     string
+		room     string
+		want     int
+		archived bool
+	}{
+		{"configured room", "lobby", http.StatusNoContent, true},
+		{"room with stored history", "old", http.StatusNoContent, true},
+		{"unknown room", "nowhere", http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.AdminToken = "secret"
+			cfg.Rooms["lobby"] = true
+			ts := startServer(t, cfg)
+			h := ts.srv.hub
+			h.readStore(func(s MessageStore) {
+				s.Append("old", Message{ID: "1", Type: typeChat, Room: "old"})
+			})
+
+			resp := ts.post(t, "/admin/rooms/"+tt.room+"/archive", "")
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			var archived, loaded bool
+			h.do(func() {
+				archived = !h.archived[tt.room].IsZero()
+				_, loaded = h.rooms[tt.room]
+			})
+			if archived != tt.archived {
+				t.Errorf("archived = %v, want %v", archived, tt.archived)
+			}
+			if loaded {
+				t.Errorf("archiving loaded #%s", tt.room)
+			}
+		})
+	}
+}
+
+// TestPruneArchived checks that only archived rooms out of memory count
+// against -max-archived-rooms, and that the longest archived go first.
+func TestPruneArchived(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxArchived int
+		want        []string
+	}{
+		{"unlimited", 0, []string{"a", "b", "c", "live"}},
+		{"under the cap", 3, []string{"a", "b", "c", "live"}},
+		{"over the cap", 2, []string{"b", "c", "live"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.MaxArchivedRooms = tt.maxArchived
+			h := NewHub(cfg)
+			start := time.Now().Add(-time.Hour)
+			for i, name := range []string{"live", "a", "b", "c"} {
+				h.archived[name] = start.Add(time.Duration(i) * time.Minute)
+				h.pins[name] = []pin{{}}
+			}
+			h.room("live")
+
+			h.pruneArchived()
+			if got := slices.Sorted(maps.Keys(h.archived)); !slices.Equal(got, tt.want) {
+				t.Errorf("archived = %v, want %v", got, tt.want)
+			}
+			if got := slices.Sorted(maps.Keys(h.pins)); !slices.Equal(got, tt.want) {
+				t.Errorf("pins kept for %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const auditRecentSize = 500
+
+// auditEntry records one moderation or admin action.
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	Target     string    `json:"target,omitempty"`
+	RemoteAddr string    `json:"remoteAddr"`
+}
+
+// auditLog is an append-only trail of admin actions, kept apart from the
+// operational log. Recent entries are also held in memory for /admin/audit.
+type auditLog struct {
+	mu     sync.Mutex
+	file   *os.File
+	recent []auditEntry
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{}
+	if path == "" {
+		return a, nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	a.file = f
+	return a, nil
+}
+
+// record appends an entry for an admin request. The actor is taken from the
+// X-Audit-Actor header, since every admin shares the same bearer token.
+func (a *auditLog) record(r *http.Request, action, target string) {
+	actor := r.Header.Get("X-Audit-Actor")
+	if actor == "" {
+		actor = "admin"
+	}
+	entry := auditEntry{
+		Time:       time.Now().UTC(),
+		Actor:      actor,
+		Action:     action,
+		Target:     target,
+		RemoteAddr: r.RemoteAddr,
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		line, _ := json.Marshal(entry)
+		if _, err := a.file.Write(append(line, '\n')); err != nil {
+			log.Printf("Audit log write failed: %v", err)
+		}
+	}
+	if len(a.recent) == auditRecentSize {
+		a.recent = a.recent[1:]
+	}
+	a.recent = append(a.recent, entry)
+}
+
+func (a *auditLog) close() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// serveAudit lists recent audit entries, newest first.
+func serveAudit(audit *auditLog, w http.ResponseWriter, r *http.Request) {
+	audit.mu.Lock()
+	entries := make([]auditEntry, len(audit.recent))
+	for i, e := range audit.recent {
+		entries[len(entries)-1-i] = e
+	}
+	audit.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
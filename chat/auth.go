@@ -0,0 +1,78 @@
+package chat
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// tokenAuth validates the JWT passed as the token query parameter. When it is
+// configured the username comes from the token, never from the client.
+type tokenAuth struct {
+	hmacKey []byte
+	rsaKey  *rsa.PublicKey
+	claim   string
+}
+
+// loadTokenAuth returns nil when cfg sets neither a secret nor a public key.
+func loadTokenAuth(cfg Config) (*tokenAuth, error) {
+	if cfg.JWTSecret == "" && cfg.JWTPublicKeyFile == "" {
+		return nil, nil
+	}
+
+	a := &tokenAuth{claim: cfg.JWTUsernameClaim}
+	if cfg.JWTSecret != "" {
+		a.hmacKey = []byte(cfg.JWTSecret)
+	}
+	if cfg.JWTPublicKeyFile != "" {
+		pem, err := os.ReadFile(cfg.JWTPublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		a.rsaKey, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", cfg.JWTPublicKeyFile, err)
+		}
+	}
+	return a, nil
+}
+
+func (a *tokenAuth) keyFor(t *jwt.Token) (any, error) {
+	switch t.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if a.hmacKey != nil {
+			return a.hmacKey, nil
+		}
+	case *jwt.SigningMethodRSA:
+		if a.rsaKey != nil {
+			return a.rsaKey, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+}
+
+// username verifies the token's signature and expiry and returns the
+// configured claim.
+func (a *tokenAuth) username(raw string) (string, error) {
+	if raw == "" {
+		return "", errors.New("missing token")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, a.keyFor,
+		jwt.WithValidMethods([]string{"HS256", "RS256"}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	name, _ := claims[a.claim].(string)
+	if name == "" {
+		return "", fmt.Errorf("token has no %q claim", a.claim)
+	}
+	return name, nil
+}
@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Lifetime byte totals across every connection, including closed ones.
+var totalBytesIn, totalBytesOut atomic.Int64
+
+// countIn and countOut record WebSocket payload bytes for a client and the
+// lifetime totals. The per-client counts go away with the connection.
+func (c *Client) countIn(n int) {
+	c.bytesIn.Add(int64(n))
+	totalBytesIn.Add(int64(n))
+}
+
+func (c *Client) countOut(n int) {
+	c.bytesOut.Add(int64(n))
+	totalBytesOut.Add(int64(n))
+}
+
+type connectionInfo struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Room      string `json:"room"`
+	BytesIn   int64  `json:"bytesIn"`
+	BytesOut  int64  `json:"bytesOut"`
+	RTTMillis int64  `json:"rttMs"`
+}
+
+type connectionsSnapshot struct {
+	BytesIn     int64            `json:"bytesIn"`
+	BytesOut    int64            `json:"bytesOut"`
+	Connections []connectionInfo `json:"connections"`
+}
+
+// serveConnections lists every connected client with the bytes it has sent
+// and received, plus lifetime totals.
+func serveConnections(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	conns := []connectionInfo{}
+	if !hub.do(func() {
+		for client := range hub.clients {
+			conns = append(conns, connectionInfo{
+				ID:        client.id,
+				Username:  client.username,
+				Room:      client.room,
+				BytesIn:   client.bytesIn.Load(),
+				BytesOut:  client.bytesOut.Load(),
+				RTTMillis: time.Duration(client.rtt.Load()).Milliseconds(),
+			})
+		}
+	}) {
+		http.Error(w, "hub stopped", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(connectionsSnapshot{
+		BytesIn:     totalBytesIn.Load(),
+		BytesOut:    totalBytesOut.Load(),
+		Connections: conns,
+	})
+}
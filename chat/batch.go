@@ -0,0 +1,71 @@
+package chat
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// collect gathers a batch for a client that opted in: first whatever is
+// already queued, then whatever arrives within the batch delay, up to the
+// batch size. closed reports that send was closed while collecting; the
+// batch is still to be written before hanging up.
+func (c *Client) collect(first Message) (batch []Message, closed bool) {
+	batch = append(batch, first)
+	var timeout <-chan time.Time
+	if c.hub.cfg.BatchDelay > 0 {
+		timer := time.NewTimer(c.hub.cfg.BatchDelay)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for len(batch) < c.hub.cfg.BatchMax {
+		select {
+		case m, ok := <-c.send:
+			if !ok {
+				return batch, true
+			}
+			batch = append(batch, m)
+			continue
+		default:
+		}
+		if timeout == nil {
+			break
+		}
+		select {
+		case m, ok := <-c.send:
+			if !ok {
+				return batch, true
+			}
+			batch = append(batch, m)
+		case <-timeout:
+			return batch, false
+		}
+	}
+	return batch, false
+}
+
+// writeBatch writes messages as one frame holding a JSON array. A batch of
+// one goes out as a plain message, so batching clients must accept both.
+func (c *Client) writeBatch(messages []Message) bool {
+	if len(messages) == 1 {
+		return c.write(messages[0])
+	}
+	for i := range messages {
+		messages[i] = c.localize(messages[i])
+	}
+	data, err := json.Marshal(messages)
+	if err == nil {
+		err = c.writeFrame(data)
+	}
+	if err != nil {
+		log.Printf("Write error to %s (%s): %v", c.username, c.id, err)
+		c.leave()
+		return false
+	}
+	for _, m := range messages {
+		c.sent(m)
+	}
+	c.countOut(len(data))
+	log.Printf("Sent %d messages to %s (%s)", len(messages), c.username, c.id)
+	return true
+}
@@ -0,0 +1,39 @@
+package chat
+
+import (
+	"fmt"
+	"testing"
+)
+
+// BenchmarkBatchFraming writes the same messages one frame each and as
+// batches, reporting bytes on the wire per message: the framing overhead
+// batching saves, less the JSON array around it.
+func BenchmarkBatchFraming(b *testing.B) {
+	m := Message{ID: newID(), Type: typeChat, Username: "alice", Room: defaultRoom, ConnID: newID(), Content: "shall we move the standup to ten tomorrow?", Platform: "web"}
+	for _, size := range []int{1, 4, 16, 32} {
+		batch := make([]Message, size)
+		for _, batched := range []bool{false, true} {
+			b.Run(fmt.Sprintf("%d/batched=%v", size, batched), func(b *testing.B) {
+				c, written := benchClient(b, DefaultConfig())
+				b.ResetTimer()
+				for range b.N {
+					for i := range batch {
+						batch[i] = m
+					}
+					if batched {
+						if !c.writeBatch(batch) {
+							b.Fatal("write failed")
+						}
+						continue
+					}
+					for _, m := range batch {
+						if !c.write(m) {
+							b.Fatal("write failed")
+						}
+					}
+				}
+				b.ReportMetric(float64(written.Load())/float64(b.N*size), "wire-B/msg")
+			})
+		}
+	}
+}
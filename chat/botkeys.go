@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	errBadSignature = errors.New("signature doesn't match the bot's registered key")
+	errUnknownBot   = errors.New("signed message from a user with no registered bot key")
+)
+
+// loadBotKeys reads the bot key file.
+func loadBotKeys(path string) (map[string]ed25519.PublicKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := make(map[string]ed25519.PublicKey)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, encoded, ok := strings.Cut(line, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("line %d: expected username=base64key", n)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("line %d: key for %s must be a base64 %d-byte Ed25519 public key", n, name, ed25519.PublicKeySize)
+		}
+		keys[name] = ed25519.PublicKey(key)
+	}
+	return keys, scanner.Err()
+}
+
+// signedPayload is what a bot signs: the room it posts to as given in the
+// request, a newline, then the content exactly as sent. Including the room
+// stops a signed announcement being replayed into another room.
+func signedPayload(room, content string) []byte {
+	return []byte(room + "\n" + content)
+}
+
+// verifyBot checks a posted message's signature. Registered bots must sign
+// every post; anyone else may not send a signature, so a bad key file entry
+// shows up instead of silently posting unverified. It reports whether the
+// message is verified.
+func verifyBot(keys map[string]ed25519.PublicKey, username, room, content, signature string) (bool, error) {
+	key, registered := keys[username]
+	if !registered {
+		if signature != "" {
+			return false, errUnknownBot
+		}
+		return false, nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(key, signedPayload(room, content), sig) {
+		return false, errBadSignature
+	}
+	return true, nil
+}
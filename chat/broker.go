@@ -0,0 +1,80 @@
+package chat
+
+import "log"
+
+// Broker fans room messages out between server instances. Subscribers see
+// every published message, including their own instance's; the hub drops
+// those by message ID.
+type Broker interface {
+	Publish(room string, m Message) error
+	Subscribe(fn func(room string, m Message)) error
+	Close() error
+}
+
+const (
+	brokerQueueSize = 1024
+	seenIDsSize     = 4096
+)
+
+// idSet remembers the most recent message IDs, forgetting the oldest once
+// full. It is owned by Run().
+type idSet struct {
+	ids  map[string]bool
+	ring []string
+	next int
+}
+
+func newIDSet(size int) *idSet {
+	return &idSet{ids: make(map[string]bool, size), ring: make([]string, size)}
+}
+
+// add records id and reports whether it was new.
+func (s *idSet) add(id string) bool {
+	if s.ids[id] {
+		return false
+	}
+	if old := s.ring[s.next]; old != "" {
+		delete(s.ids, old)
+	}
+	s.ring[s.next] = id
+	s.next = (s.next + 1) % len(s.ring)
+	s.ids[id] = true
+	return true
+}
+
+// useBroker connects the hub to other instances. It must be called before
+// Run().
+func (h *Hub) useBroker(b Broker) error {
+	h.broker = b
+	h.outgoing = make(chan Message, brokerQueueSize)
+	return b.Subscribe(func(room string, m Message) {
+		m.Room = room
+		h.injectRemote(m)
+	})
+}
+
+// relay queues a local broadcast for the broker without ever blocking Run();
+// if the broker can't keep up the message stays local.
+func (h *Hub) relay(m Message) {
+	if h.broker == nil {
+		return
+	}
+	select {
+	case h.outgoing <- m:
+	default:
+		log.Printf("Broker queue full, message %s not relayed", m.ID)
+	}
+}
+
+func (h *Hub) publishLoop() {
+	for {
+		select {
+		case m := <-h.outgoing:
+			if err := h.broker.Publish(m.Room, m); err != nil {
+				log.Printf("Failed to relay message %s: %v", m.ID, err)
+			}
+		case <-h.done:
+			return
+		}
+	}
+}
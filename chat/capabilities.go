@@ -0,0 +1,77 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// capabilities tells a client which optional features this server has
+// turned on, so it can set up its UI instead of finding out by failing.
+type capabilities struct {
+	Resume     bool   `json:"resume"`
+	Ephemeral  bool   `json:"ephemeral"`
+	Pins       bool   `json:"pins"`
+	Markdown   bool   `json:"markdown"`
+	Latency    bool   `json:"latency"`
+	Outbox     bool   `json:"outbox"`
+	Mentions   bool   `json:"mentions"`
+	DirectMsgs bool   `json:"directMessages"`
+	Batching   bool   `json:"batching"`
+	Limits     limits `json:"limits"`
+}
+
+// limits are the bounds a well-behaved client should stay inside.
+type limits struct {
+	MaxFrameBytes   int64 `json:"maxFrameBytes"`
+	MaxMessageRunes int   `json:"maxMessageRunes"`
+	MaxTTLSeconds   int   `json:"maxTtlSeconds"`
+	MaxPins         int   `json:"maxPins"`
+	HistorySize     int   `json:"historySize"`
+	HistoryPageMax  int   `json:"historyPageMax"`
+}
+
+func (h *Hub) capabilities() *capabilities {
+	return &capabilities{
+		Resume:     true,
+		Ephemeral:  h.cfg.MaxTTL > 0,
+		Pins:       h.maxPins > 0,
+		Markdown:   validFormats[formatMarkdown],
+		Latency:    h.cfg.PingInterval > 0 && h.cfg.PushRTT,
+		Outbox:     h.outboxGrace > 0,
+		Mentions:   true,
+		DirectMsgs: true,
+		Batching:   h.cfg.BatchMax > 1,
+		Limits: limits{
+			MaxFrameBytes:   h.cfg.MaxFrameBytes,
+			MaxMessageRunes: h.cfg.MaxMessageRunes,
+			MaxTTLSeconds:   int(h.cfg.MaxTTL.Seconds()),
+			MaxPins:         h.maxPins,
+			HistorySize:     h.cfg.historySizes().forRoom(""),
+			HistoryPageMax:  h.cfg.HistoryPageMax,
+		},
+	}
+}
+
+// serveCapabilities answers the same question as the connected handshake
+// for clients that want to know before they connect.
+func serveCapabilities(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hub.capabilities())
+}
+
+// connectedMessage confirms a registration: the username and room the
+// client actually got, which can differ from what it asked for, and what
+// the server supports.
+func (h *Hub) connectedMessage(client *Client) Message {
+	return Message{
+		Type:         typeConnected,
+		Username:     client.username,
+		Room:         client.room,
+		ConnID:       client.id,
+		Capabilities: h.capabilities(),
+		// Ahead of the replay, and not taking up room in the send buffer.
+		Priority:  true,
+		Timestamp: time.Now(),
+	}
+}
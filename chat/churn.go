@@ -0,0 +1,102 @@
+package chat
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// churnTracker spots IPs that connect and disconnect over and over, like a
+// client in a crash loop, and makes them wait before connecting again. The
+// per-IP connection cap doesn't catch this, since such a client never has
+// many connections open at once. Like connLimiter it is used from HTTP
+// handlers, so it has a lock.
+type churnTracker struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	cooldown  time.Duration
+	maxWait   time.Duration
+	ips       map[string]*churnState
+	lastSweep time.Time
+}
+
+type churnState struct {
+	connects []time.Time
+	// strikes counts cooldowns in a row; each one is twice as long.
+	strikes int
+	until   time.Time
+}
+
+func newChurnTracker(limit int, window, cooldown, maxWait time.Duration) *churnTracker {
+	return &churnTracker{limit: limit, window: window, cooldown: cooldown, maxWait: maxWait, ips: make(map[string]*churnState)}
+}
+
+// connect records a connection attempt from ip and returns how long it
+// must wait first, or 0 if it may go ahead.
+func (t *churnTracker) connect(ip string, now time.Time) time.Duration {
+	if t.limit <= 0 {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sweep(now)
+
+	s, ok := t.ips[ip]
+	if !ok {
+		s = &churnState{}
+		t.ips[ip] = s
+	}
+	if now.Before(s.until) {
+		return s.until.Sub(now)
+	}
+	if s.strikes > 0 && now.Sub(s.until) > t.window {
+		// Behaved for a whole window since the last cooldown.
+		s.strikes = 0
+	}
+	cutoff := now.Add(-t.window)
+	for len(s.connects) > 0 && s.connects[0].Before(cutoff) {
+		s.connects = s.connects[1:]
+	}
+	s.connects = append(s.connects, now)
+	if len(s.connects) <= t.limit {
+		return 0
+	}
+
+	s.strikes++
+	wait := min(t.cooldown<<(s.strikes-1), t.maxWait)
+	if wait <= 0 {
+		// The shift overflowed.
+		wait = t.maxWait
+	}
+	s.until = now.Add(wait)
+	s.connects = nil
+	log.Printf("Cooling down %s for %v: %d connections within %v (strike %d)", ip, wait, t.limit+1, t.window, s.strikes)
+	return wait
+}
+
+// sweep forgets IPs that have been quiet for a window, at most once a
+// window.
+func (t *churnTracker) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.window {
+		return
+	}
+	t.lastSweep = now
+	cutoff := now.Add(-t.window)
+	for ip, s := range t.ips {
+		if now.After(s.until.Add(t.window)) && (len(s.connects) == 0 || s.connects[len(s.connects)-1].Before(cutoff)) {
+			delete(t.ips, ip)
+		}
+	}
+}
+
+// rejectChurn hangs up on a client in a cooldown. The reason carries the
+// wait as a retry hint, the same way closeReason does.
+func rejectChurn(conn *websocket.Conn, wait time.Duration) {
+	reason := fmt.Sprintf("retry=%d", wait.Milliseconds())
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, reason))
+	conn.Close()
+}
@@ -0,0 +1,10 @@
+package chat
+
+import "github.com/gorilla/websocket"
+
+// writeFrame writes one text frame, compressing it only if it is big enough
+// to benefit. Compression is a no-op unless the client negotiated it.
+func (c *Client) writeFrame(data []byte) error {
+	c.conn.EnableWriteCompression(len(data) >= c.hub.cfg.CompressionMinBytes)
+	return c.writeMessage(websocket.TextMessage, data)
+}
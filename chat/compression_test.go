@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// countingConn counts the bytes written to a connection.
+type countingConn struct {
+	net.Conn
+	written *atomic.Int64
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+type countingListener struct {
+	net.Listener
+	written *atomic.Int64
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn, l.written}, nil
+}
+
+// benchClient connects a server-side Client to a peer that reads and
+// discards everything. written counts the bytes the Client puts on the
+// wire, frame headers included.
+func benchClient(b *testing.B, cfg Config) (c *Client, written *atomic.Int64) {
+	b.Helper()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	hub := NewHub(cfg)
+	conns := make(chan *websocket.Conn, 1)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := hub.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	written = new(atomic.Int64)
+	ts.Listener = countingListener{ts.Listener, written}
+	ts.Start()
+	b.Cleanup(ts.Close)
+
+	dialer := websocket.Dialer{EnableCompression: cfg.Compression}
+	peer, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { peer.Close() })
+	go func() {
+		for {
+			if _, _, err := peer.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	conn := <-conns
+	b.Cleanup(func() { conn.Close() })
+	c = &Client{id: newID(), hub: hub, conn: conn, username: "bench", room: defaultRoom}
+	written.Store(0)
+	return c, written
+}
+
+var benchWords = strings.Fields(`shall we move the standup to ten tomorrow I think
+	the deploy went fine but latency on the east cluster looks a bit high can
+	someone check the dashboard before lunch thanks also reminder that the
+	retro is on friday and please add your notes to the doc`)
+
+// benchFrame is a chat message as JSON of about size bytes, with content
+// made of words drawn at random so it compresses like real text.
+func benchFrame(size int) []byte {
+	rng := rand.New(rand.NewPCG(1, uint64(size)))
+	m := Message{ID: newID(), Type: typeChat, Username: "alice", Room: defaultRoom, ConnID: newID(), Platform: "web"}
+	for {
+		data, _ := json.Marshal(m)
+		if len(data) >= size {
+			return data
+		}
+		m.Content += benchWords[rng.IntN(len(benchWords))] + " "
+	}
+}
+
+// BenchmarkCompressionThreshold writes frames of several sizes with
+// compression always on and with it off, reporting bytes on the wire per
+// frame, to show where -compression-min-bytes should sit.
+func BenchmarkCompressionThreshold(b *testing.B) {
+	for _, size := range []int{256, 384, 512, 1024, 4096} {
+		data := benchFrame(size)
+		for _, compress := range []bool{false, true} {
+			b.Run(fmt.Sprintf("%dB/compress=%v", size, compress), func(b *testing.B) {
+				cfg := DefaultConfig()
+				cfg.Compression = true
+				cfg.CompressionMinBytes = math.MaxInt
+				if compress {
+					cfg.CompressionMinBytes = 0
+				}
+				c, written := benchClient(b, cfg)
+				b.ResetTimer()
+				for range b.N {
+					if err := c.writeFrame(data); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(written.Load())/float64(b.N), "wire-B/op")
+			})
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package chat
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"maps"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config is every setting the server has. LoadConfig fills it from the
+// command line, the environment and a config file; programs embedding the
+// server can start from DefaultConfig and change what they need, or bind
+// it to their own flags with RegisterFlags.
+type Config struct {
+	// Addr is the address the HTTP server listens on.
+	Addr string
+	// HubBuffer sizes the hub's broadcast, register and unregister queues.
+	// A buffer lets a burst queue up instead of stalling every readPump on
+	// a busy Run() loop, at the cost of up to that many messages held in
+	// memory. 0 makes them unbuffered.
+	HubBuffer        int
+	HandshakeTimeout time.Duration
+	// MaxFrameBytes caps an incoming WebSocket message before any JSON
+	// decoding happens, so it bounds memory per read. It must stay larger
+	// than the biggest legitimate message including its JSON envelope;
+	// MaxMessageRunes is the limit on content.
+	MaxFrameBytes int64
+	Compression   bool
+	// CompressionMinBytes leaves small frames uncompressed: deflate adds a
+	// few bytes of framing, and a typical chat message of around 200 bytes
+	// of JSON has too little repetition to win them back.
+	CompressionMinBytes int
+	// ShutdownTimeout is how long requests in flight get to finish when
+	// the server stops; ShutdownGrace is how long WebSocket connections
+	// then get to receive their close frame.
+	ShutdownTimeout time.Duration
+	ShutdownGrace   time.Duration
+	Debug           bool
+	// LogContent puts message text in the log; otherwise only metadata
+	// such as length is logged.
+	LogContent bool
+
+	// Who clients are. With no JWT key and no UsernameHeader, clients
+	// name themselves.
+	AdminToken       string
+	JWTSecret        string
+	JWTPublicKeyFile string
+	JWTUsernameClaim string
+	// UsernameHeader names a header set by an authenticating reverse
+	// proxy. It is only as trustworthy as the proxy: it must overwrite or
+	// strip any copy the client sends, and clients must not be able to
+	// reach this server except through it. With TrustedProxies set,
+	// requests that didn't come from one of them are refused outright.
+	UsernameHeader string
+	// TrustedProxies are the networks whose X-Forwarded-For is believed.
+	TrustedProxies []netip.Prefix
+
+	// StaticDir replaces the built-in page. Only the built-in page is
+	// filled in by the server, so a custom UI has to work out its own
+	// WebSocket URL, e.g. from location.host.
+	StaticDir     string
+	GzipResponses bool
+	GzipRequests  bool
+
+	// Storage and cross-instance relaying. Empty URLs keep everything in
+	// this process.
+	RedisURL        string
+	StorageOptional bool
+	StorageBuffer   int
+	NATSURL         string
+	AuditLog        string
+
+	// Files of room ACLs, room encryption keys and bot signing keys.
+	RoomACLFile  string
+	RoomKeysFile string
+	BotKeysFile  string
+	// DBKey is a base64 AES key that encrypts stored messages in rooms
+	// without a key of their own, and stored direct messages.
+	DBKey string
+
+	// HistorySize is how many messages a room keeps for replay, unless
+	// RoomHistorySizes says otherwise.
+	HistorySize      int
+	RoomHistorySizes map[string]int
+	// Scrollback is how many messages a room keeps for fetch_history to
+	// page back through. Rooms always keep at least their history size,
+	// and a room with no history keeps none.
+	Scrollback     int
+	HistoryPageMax int
+	// ReplayMaxAge limits what a joining client is replayed, separately
+	// from how much history is kept, so a new joiner isn't shown a stale
+	// conversation.
+	ReplayMaxAge         time.Duration
+	MaxConcurrentReplays int
+	InboxSize            int
+	InboxTTL             time.Duration
+	// MaxInboxes caps how many offline users the in-memory store holds
+	// direct messages for at once; Redis expires inboxes on its own.
+	MaxInboxes int
+
+	// MaxMessageRunes is the user-facing length limit. It counts runes,
+	// not bytes, so an emoji or a CJK character counts as one whatever its
+	// UTF-8 size.
+	MaxMessageRunes int
+	// MaxMetadataBytes caps everything in a message besides its content,
+	// so optional fields can't smuggle a large payload into the fan-out.
+	MaxMetadataBytes int
+	MaxTTL           time.Duration
+	MaxPendingExpiry int
+	// EmptyContent is emptyContentReject or emptyContentAllow: whether
+	// chat and direct messages that are nothing but whitespace or
+	// invisible characters are turned away.
+	EmptyContent        string
+	SafeMarkdown        bool
+	NormalizeTrim       bool
+	NormalizeBlankLines bool
+	NormalizeControl    bool
+	NormalizeNFC        bool
+	MaxPins             int
+	MentionNotify       bool
+
+	PingInterval           time.Duration
+	PongTimeout            time.Duration
+	PushRTT                bool
+	BatchMax               int
+	BatchDelay             time.Duration
+	ReconnectBackoff       time.Duration
+	PresenceInterval       time.Duration
+	PresenceBatchThreshold int
+	OutboxGrace            time.Duration
+	OutboxSize             int
+
+	// UnknownRoom is roomPolicyCreate, roomPolicyReject or
+	// roomPolicyRedirect; Rooms are the rooms that exist up front besides
+	// the default room.
+	UnknownRoom string
+	Rooms       map[string]bool
+	// RoomIdleTimeout evicts rooms nobody is in and nobody has posted to
+	// for that long; MaxRooms evicts the least recently active empty one
+	// to make space. Neither touches the default room or Rooms.
+	RoomIdleTimeout time.Duration
+	MaxRooms        int
+	// RoomArchiveAfter archives rooms instead of forgetting them: an
+	// archived room keeps its history and pins and can still be joined and
+	// read, but takes no new messages until an admin unarchives it.
+	// MaxArchivedRooms caps how many archived rooms are kept once they
+	// have been evicted from memory; past it the longest archived is
+	// forgotten, history and pins included.
+	RoomArchiveAfter time.Duration
+	MaxArchivedRooms int
+	RoomRate         float64
+	// Per-room settings, keyed by room name.
+	RoomRates   map[string]float64
+	SlowModes   map[string]time.Duration
+	HiddenRooms map[string]bool
+	RoomFormats map[string]ContentRule
+
+	MaxConnsPerIP    int
+	ChurnLimit       int
+	ChurnWindow      time.Duration
+	ChurnCooldown    time.Duration
+	ChurnMaxCooldown time.Duration
+	StallTimeout     time.Duration
+	MaxConnLifetime  time.Duration
+	// Load shedding turns away new messages and connections while the
+	// hub's broadcast queue is backed up, so clients get a clear "try
+	// again" instead of everything slowing down. It starts when the queue
+	// is ShedAt full and stops once it has drained to ShedResume, so it
+	// doesn't flap at the edge.
+	ShedAt     float64
+	ShedResume float64
+
+	// RoomAuthorizer, if set, decides who may join which room. It can't be
+	// combined with RoomACLFile.
+	RoomAuthorizer RoomAuthorizer
+
+	// Sessions is sessionsMultiple or sessionsTakeover.
+	Sessions string
+	// AnonymousNames names one of the built-in name generators, friendly
+	// or numbered. NameGenerator, if set, is used instead.
+	AnonymousNames string
+	NameGenerator  NameGenerator
+
+	Unfurl     bool
+	UnfurlRate float64
+	// UnfurlAllow, when set, is the only hosts (and their subdomains)
+	// previews are fetched from; UnfurlDeny hosts are never fetched.
+	UnfurlAllow []string
+	UnfurlDeny  []string
+}
+
+// DefaultConfig is the Config the server runs with when given no flags.
+func DefaultConfig() Config {
+	return Config{
+		Addr:                ":8080",
+		HubBuffer:           64,
+		HandshakeTimeout:    10 * time.Second,
+		MaxFrameBytes:       64 << 10,
+		CompressionMinBytes: 256,
+		ShutdownTimeout:     5 * time.Second,
+		ShutdownGrace:       5 * time.Second,
+
+		JWTUsernameClaim: "sub",
+
+		GzipResponses: true,
+		GzipRequests:  true,
+
+		StorageBuffer: 1000,
+
+		HistorySize:          50,
+		RoomHistorySizes:     map[string]int{},
+		Scrollback:           1000,
+		HistoryPageMax:       100,
+		ReplayMaxAge:         24 * time.Hour,
+		MaxConcurrentReplays: 32,
+		InboxSize:            100,
+		InboxTTL:             7 * 24 * time.Hour,
+		MaxInboxes:           10000,
+
+		MaxMessageRunes:     4000,
+		MaxMetadataBytes:    2048,
+		MaxTTL:              24 * time.Hour,
+		MaxPendingExpiry:    10000,
+		EmptyContent:        emptyContentReject,
+		NormalizeTrim:       true,
+		NormalizeBlankLines: true,
+		NormalizeControl:    true,
+		MaxPins:             10,
+		MentionNotify:       true,
+
+		PingInterval:           30 * time.Second,
+		PongTimeout:            60 * time.Second,
+		PushRTT:                true,
+		BatchMax:               32,
+		BatchDelay:             10 * time.Millisecond,
+		ReconnectBackoff:       time.Second,
+		PresenceInterval:       5 * time.Second,
+		PresenceBatchThreshold: 50,
+		OutboxSize:             100,
+
+		UnknownRoom:      roomPolicyCreate,
+		Rooms:            map[string]bool{},
+		RoomIdleTimeout:  10 * time.Minute,
+		MaxArchivedRooms: 1000,
+		RoomRate:         20,
+		RoomRates:        map[string]float64{},
+		SlowModes:        map[string]time.Duration{},
+		HiddenRooms:      map[string]bool{},
+		RoomFormats:      map[string]ContentRule{},
+
+		ChurnWindow:      time.Minute,
+		ChurnCooldown:    10 * time.Second,
+		ChurnMaxCooldown: 10 * time.Minute,
+		StallTimeout:     30 * time.Second,
+		ShedAt:           0.9,
+		ShedResume:       0.5,
+
+		Sessions:       sessionsMultiple,
+		AnonymousNames: "friendly",
+
+		UnfurlRate: 2,
+	}
+}
+
+// clone copies cfg deeply enough that changing one copy's maps or slices
+// leaves the other alone.
+func (cfg Config) clone() Config {
+	cfg.TrustedProxies = slices.Clone(cfg.TrustedProxies)
+	cfg.RoomHistorySizes = maps.Clone(cfg.RoomHistorySizes)
+	cfg.Rooms = maps.Clone(cfg.Rooms)
+	cfg.RoomRates = maps.Clone(cfg.RoomRates)
+	cfg.SlowModes = maps.Clone(cfg.SlowModes)
+	cfg.HiddenRooms = maps.Clone(cfg.HiddenRooms)
+	cfg.RoomFormats = maps.Clone(cfg.RoomFormats)
+	cfg.UnfurlAllow = slices.Clone(cfg.UnfurlAllow)
+	cfg.UnfurlDeny = slices.Clone(cfg.UnfurlDeny)
+	return cfg
+}
+
+// LoadConfig parses args, the command line without the program name, then
+// fills in flags it didn't set from CHAT_* environment variables, e.g.
+// CHAT_MAX_ROOMS for -max-rooms, and then from the -config file. It uses a
+// flag set of its own, so it leaves flag.CommandLine alone. Asking for
+// -help returns flag.ErrHelp.
+func LoadConfig(args []string) (Config, error) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	cfg := DefaultConfig()
+	cfg.RegisterFlags(fs)
+	configFile := fs.String("config", "", "file of flag=value lines, e.g. max-rooms=100; command-line flags and CHAT_* environment variables override it")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := "CHAT_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := os.LookupEnv(name)
+		if !ok || set[f.Name] || err != nil {
+			return
+		}
+		if err = fs.Set(f.Name, value); err != nil {
+			err = fmt.Errorf("%s: %w", name, err)
+		}
+		set[f.Name] = true
+	})
+	if err != nil {
+		return Config{}, err
+	}
+
+	if *configFile != "" {
+		if err := loadConfigFile(fs, *configFile, set); err != nil {
+			return Config{}, fmt.Errorf("%s: %w", *configFile, err)
+		}
+	}
+	return cfg, nil
+}
+
+// loadConfigFile sets the flags in a file of flag=value lines, skipping
+// those already set.
+func loadConfigFile(fs *flag.FlagSet, path string, set map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || fs.Lookup(name) == nil {
+			return fmt.Errorf("line %d: expected flag=value for a known flag", n)
+		}
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return scanner.Err()
+}
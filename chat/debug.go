@@ -0,0 +1,71 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// Live pump goroutine counts, for spotting leaks.
+var readPumps, writePumps atomic.Int64
+
+type channelDepth struct {
+	Len int `json:"len"`
+	Cap int `json:"cap"`
+}
+
+type debugClient struct {
+	ID       string       `json:"id"`
+	Username string       `json:"username"`
+	Room     string       `json:"room"`
+	Send     channelDepth `json:"send"`
+}
+
+type debugSnapshot struct {
+	ReadPumps  int64                   `json:"readPumps"`
+	WritePumps int64                   `json:"writePumps"`
+	Hub        map[string]channelDepth `json:"hub"`
+	Clients    []debugClient           `json:"clients"`
+}
+
+// debugClients lists every registered client's send buffer usage. It must
+// only be called from Run().
+func (h *Hub) debugClients() []debugClient {
+	out := make([]debugClient, 0, len(h.clients))
+	for client := range h.clients {
+		out = append(out, debugClient{
+			ID:       client.id,
+			Username: client.username,
+			Room:     client.room,
+			Send:     channelDepth{len(client.send), cap(client.send)},
+		})
+	}
+	return out
+}
+
+func serveDebug(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	reply := make(chan []debugClient, 1)
+	select {
+	case hub.inspect <- reply:
+	case <-hub.done:
+		http.Error(w, "hub stopped", http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	snap := debugSnapshot{
+		ReadPumps:  readPumps.Load(),
+		WritePumps: writePumps.Load(),
+		Hub: map[string]channelDepth{
+			"broadcast":  {len(hub.broadcast), cap(hub.broadcast)},
+			"register":   {len(hub.register), cap(hub.register)},
+			"unregister": {len(hub.unregister), cap(hub.unregister)},
+			"commands":   {len(hub.commands), cap(hub.commands)},
+		},
+		Clients: <-reply,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snap)
+}
@@ -0,0 +1,97 @@
+package chat
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// roomInfo is one room in GET /api/rooms.
+type roomInfo struct {
+	Name         string    `json:"name"`
+	Members      int       `json:"members"`
+	LastActive   time.Time `json:"lastActive,omitzero"`
+	Archived     bool      `json:"archived"`
+	ArchivedAt   time.Time `json:"archivedAt,omitzero"`
+	Discoverable bool      `json:"discoverable"`
+}
+
+// directory lists the rooms the hub knows about: live ones, configured ones
+// nobody has joined yet and archived ones that have since been evicted from
+// memory. Unless all is set it leaves out hidden and archived rooms. It must
+// only be called from Run().
+func (h *Hub) directory(all bool) []roomInfo {
+	names := map[string]bool{defaultRoom: true}
+	maps.Copy(names, h.cfg.Rooms)
+	for name := range h.rooms {
+		names[name] = true
+	}
+	for name := range h.archived {
+		names[name] = true
+	}
+
+	rooms := []roomInfo{}
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		info := roomInfo{Name: name, ArchivedAt: h.archived[name], Discoverable: !h.hidden[name]}
+		info.Archived = !info.ArchivedAt.IsZero()
+		if !all && (info.Archived || !info.Discoverable) {
+			continue
+		}
+		if room, ok := h.rooms[name]; ok {
+			info.Members = len(room.clients)
+			info.LastActive = room.lastActive
+		}
+		rooms = append(rooms, info)
+	}
+	return rooms
+}
+
+// serveRooms is the room directory. Admins see every room; everyone else
+// only sees discoverable rooms that are open for messages.
+func serveRooms(hub *Hub, auth *tokenAuth, w http.ResponseWriter, r *http.Request) {
+	all := isAdmin(hub.cfg.AdminToken, r)
+	if !all && !checkAPIToken(auth, w, r) {
+		return
+	}
+	var rooms []roomInfo
+	if !hub.do(func() { rooms = hub.directory(all) }) {
+		http.Error(w, "hub stopped", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rooms)
+}
+
+// serveDiscoverable shows a room in the directory or hides it.
+func serveDiscoverable(hub *Hub, audit *auditLog, discoverable bool, w http.ResponseWriter, r *http.Request) {
+	room := r.PathValue("room")
+	if !validRoomName(room) {
+		http.Error(w, "invalid room name", http.StatusBadRequest)
+		return
+	}
+	var changed bool
+	if !hub.do(func() {
+		changed = hub.hidden[room] == discoverable
+		if discoverable {
+			delete(hub.hidden, room)
+		} else {
+			hub.hidden[room] = true
+		}
+	}) {
+		http.Error(w, "hub stopped", http.StatusServiceUnavailable)
+		return
+	}
+	if changed {
+		action := "hide"
+		if discoverable {
+			action = "show"
+		}
+		audit.record(r, action, "#"+room)
+		log.Printf("Room #%s: %s in the directory (admin)", room, action)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
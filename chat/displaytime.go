@@ -0,0 +1,39 @@
+package chat
+
+import (
+	"errors"
+	"net/url"
+	"time"
+)
+
+// displayLayouts are how each locale writes a date and time. Month names
+// would need translating, so they're all numeric.
+var displayLayouts = map[string]string{
+	"en": "01/02/2006 3:04 PM",
+	"es": "02/01/2006 15:04",
+	"fr": "02/01/2006 15:04",
+	"de": "02.01.2006 15:04",
+}
+
+// parseDisplayZone reads the tz query parameter a client sends to have
+// messages carry a displayTime in that IANA time zone. Without one it
+// returns nil and messages go out with only the UTC timestamp.
+func parseDisplayZone(q url.Values) (*time.Location, error) {
+	tz := q.Get("tz")
+	if tz == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, errors.New("unknown time zone " + tz)
+	}
+	return loc, nil
+}
+
+// displayTime formats a timestamp for a client that asked for one.
+func (c *Client) displayTime(t time.Time) string {
+	if c.displayZone == nil || t.IsZero() {
+		return ""
+	}
+	return t.In(c.displayZone).Format(displayLayouts[c.locale])
+}
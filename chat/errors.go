@@ -0,0 +1,97 @@
+package chat
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Errors that decide how a problem is reported. Wrap them with wrapf or %w
+// and let closeCode and httpStatus pick the code, so the pumps, hub and REST
+// handlers all answer the same way.
+var (
+	errRoomNotFound   = errors.New("room not found")
+	errRoomForbidden  = errors.New("not allowed in this room")
+	errRoomsFull      = errors.New("room limit reached")
+	errRateLimited    = errors.New("rate limited")
+	errMessageTooLong = errors.New("message too long")
+	errNotConsuming   = errors.New("not consuming messages")
+	errRoomArchived   = errors.New("room is archived")
+	errServerBusy     = errors.New("server busy")
+	errShuttingDown   = errors.New("server is shutting down")
+	errRoomFormat     = errors.New("message doesn't follow the room's format")
+	// errReconnectRequired ends a connection that has reached its
+	// maximum lifetime.
+	errReconnectRequired = errors.New("reconnect required")
+	// errSessionReplaced ends a session when the same user connects
+	// again under -sessions=takeover.
+	errSessionReplaced = errors.New("session replaced elsewhere")
+)
+
+// Close codes for rejected joins, after the matching HTTP statuses.
+const (
+	closeRoomForbidden = 4403
+	closeRoomNotFound  = 4404
+	// closeSessionReplaced tells a client not to reconnect: doing so would
+	// just take the session back from the user's newer one.
+	closeSessionReplaced = 4409
+)
+
+// maxCloseReason is the longest reason a close frame can carry.
+const maxCloseReason = 123
+
+func closeCode(err error) int {
+	switch {
+	case errors.Is(err, errRoomNotFound):
+		return closeRoomNotFound
+	case errors.Is(err, errRoomForbidden):
+		return closeRoomForbidden
+	case errors.Is(err, errRoomsFull), errors.Is(err, errServerBusy):
+		return websocket.CloseTryAgainLater
+	case errors.Is(err, errMessageTooLong):
+		return websocket.CloseMessageTooBig
+	case errors.Is(err, errRateLimited), errors.Is(err, errNotConsuming):
+		return websocket.ClosePolicyViolation
+	case errors.Is(err, errReconnectRequired):
+		return websocket.CloseServiceRestart
+	case errors.Is(err, errShuttingDown):
+		return websocket.CloseGoingAway
+	case errors.Is(err, errSessionReplaced):
+		return closeSessionReplaced
+	}
+	return websocket.CloseInternalServerErr
+}
+
+// closeMessage is the close frame for hanging up on a client because of err.
+func closeMessage(err error) []byte {
+	reason := err.Error()
+	if len(reason) > maxCloseReason {
+		reason = strings.ToValidUTF8(reason[:maxCloseReason], "")
+	}
+	return websocket.FormatCloseMessage(closeCode(err), reason)
+}
+
+func httpStatus(err error) int {
+	var invalid *validationError
+	switch {
+	case errors.Is(err, errRoomNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errRoomForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, errRoomsFull), errors.Is(err, errServerBusy), errors.Is(err, errShuttingDown):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errMessageTooLong):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errRoomArchived):
+		return http.StatusConflict
+	case errors.Is(err, errRoomFormat):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &invalid):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,99 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	eventConnect    = "connect"
+	eventDisconnect = "disconnect"
+	eventMessage    = "message"
+
+	eventBufferSize = 64
+)
+
+// event is a lifecycle notification for observers. It never carries message
+// content.
+type event struct {
+	Type      string    `json:"type"`
+	Username  string    `json:"username"`
+	ConnID    string    `json:"connId"`
+	Clients   int       `json:"clients"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// eventBus fans hub events out to subscribers. Each subscriber has a bounded
+// buffer and events are dropped for it when the buffer is full, so a slow
+// reader can never block the hub.
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan event]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[chan event]struct{})}
+}
+
+func (b *eventBus) subscribe() chan event {
+	ch := make(chan event, eventBufferSize)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *eventBus) unsubscribe(ch chan event) {
+	b.mu.Lock()
+	delete(b.subs, ch)
+	b.mu.Unlock()
+}
+
+func (b *eventBus) publish(ev event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// serveEvents streams hub events as Server-Sent Events until the client goes
+// away or the server shuts down.
+func serveEvents(ctx context.Context, hub *Hub, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	ch := hub.events.subscribe()
+	defer hub.events.unsubscribe(ch)
+
+	for {
+		select {
+		case ev := <-ch:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
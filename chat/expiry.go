@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"container/heap"
+	"errors"
+	"log"
+	"time"
+)
+
+var errTooManyExpiring = errors.New("too many ephemeral messages pending, try again later")
+
+type expiry struct {
+	at   time.Time
+	id   string
+	room string
+}
+
+// expiryQueue is a min-heap of pending expirations, soonest first.
+type expiryQueue []expiry
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+func (q expiryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x any)        { *q = append(*q, x.(expiry)) }
+func (q *expiryQueue) Pop() any {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// scheduleExpiry records when an ephemeral room message should disappear. It
+// must only be called from Run().
+func (h *Hub) scheduleExpiry(message Message) error {
+	if h.expiring.Len() >= h.maxPendingExpiry {
+		return errTooManyExpiring
+	}
+	heap.Push(&h.expiring, expiry{at: message.ExpiresAt, id: message.ID, room: message.Room})
+	return nil
+}
+
+// expireMessages removes every message whose time is up from its room's
+// history and tells the room to delete it.
+func (h *Hub) expireMessages(now time.Time) {
+	for h.expiring.Len() > 0 && !h.expiring[0].at.After(now) {
+		e := heap.Pop(&h.expiring).(expiry)
+		h.storeLater("removal of expired message "+e.id, func(s MessageStore) {
+			if err := s.Remove(e.room, e.id); err != nil {
+				log.Printf("Failed to remove expired message %s: %v", e.id, err)
+			}
+		})
+		h.unpinMessage(e.room, e.id)
+		if r, ok := h.rooms[e.room]; ok {
+			h.deliver(r, Message{
+				Type:      typeDelete,
+				Username:  systemUsername,
+				Room:      e.room,
+				ID:        e.id,
+				Timestamp: now,
+			})
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package chat
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+)
+
+// roomLister is implemented by stores that can say which rooms they hold
+// history for. Export needs it; MessageStore alone only answers per room.
+type roomLister interface {
+	Rooms() ([]string, error)
+}
+
+// serveExport streams every stored message as newline-delimited JSON, one
+// room at a time, so memory use is bounded by a single room's history no
+// matter how many rooms there are.
+func serveExport(hub *Hub, audit *auditLog, w http.ResponseWriter, r *http.Request) {
+	lister, ok := hub.store.(roomLister)
+	if !ok {
+		http.Error(w, "this store can't be exported", http.StatusNotImplemented)
+		return
+	}
+	var rooms []string
+	var err error
+	if !hub.readStore(func(MessageStore) { rooms, err = lister.Rooms() }) {
+		http.Error(w, "hub stopped", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("Export failed listing rooms: %v", err)
+		http.Error(w, "history unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	slices.Sort(rooms)
+	audit.record(r, "export", "")
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="chat-export.ndjson"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	for _, room := range rooms {
+		var messages []Message
+		if !hub.readStore(func(s MessageStore) { messages, err = s.Recent(room) }) {
+			return
+		}
+		if err != nil {
+			// Headers are gone already; all we can do is stop.
+			log.Printf("Export stopped at #%s: %v", room, err)
+			return
+		}
+		for _, m := range messages {
+			if err := enc.Encode(m); err != nil {
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if r.Context().Err() != nil {
+			return
+		}
+	}
+}
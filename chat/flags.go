@@ -0,0 +1,246 @@
+package chat
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RegisterFlags defines a flag on fs for every setting in cfg, with cfg's
+// current values as the defaults, so parsing fs fills cfg in. Start from
+// DefaultConfig for the usual defaults; a zero Config works too, with every
+// default zero. Programs with flags of their own can use a separate FlagSet
+// to keep the names apart.
+func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
+	// The per-room flags fill these in, so they can't be nil.
+	if cfg.RoomHistorySizes == nil {
+		cfg.RoomHistorySizes = map[string]int{}
+	}
+	if cfg.Rooms == nil {
+		cfg.Rooms = map[string]bool{}
+	}
+	if cfg.RoomRates == nil {
+		cfg.RoomRates = map[string]float64{}
+	}
+	if cfg.SlowModes == nil {
+		cfg.SlowModes = map[string]time.Duration{}
+	}
+	if cfg.HiddenRooms == nil {
+		cfg.HiddenRooms = map[string]bool{}
+	}
+	if cfg.RoomFormats == nil {
+		cfg.RoomFormats = map[string]ContentRule{}
+	}
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "address to listen on")
+	fs.IntVar(&cfg.HubBuffer, "hub-buffer", cfg.HubBuffer, "buffer size of the hub's broadcast, register and unregister channels")
+	fs.DurationVar(&cfg.HandshakeTimeout, "handshake-timeout", cfg.HandshakeTimeout, "time allowed for reading request headers and completing the WebSocket upgrade")
+	fs.Int64Var(&cfg.MaxFrameBytes, "max-frame", cfg.MaxFrameBytes, "maximum size in bytes of an incoming WebSocket message, JSON envelope included (a transport limit; see -max-message-runes for the content limit)")
+	fs.BoolVar(&cfg.Compression, "compression", cfg.Compression, "negotiate permessage-deflate with clients that support it")
+	fs.IntVar(&cfg.CompressionMinBytes, "compression-min-bytes", cfg.CompressionMinBytes, "with -compression, send frames smaller than this uncompressed")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "time given to HTTP requests in flight to finish on shutdown")
+	fs.DurationVar(&cfg.ShutdownGrace, "shutdown-grace", cfg.ShutdownGrace, "time given to connections to receive their close frame on shutdown before they are cut off")
+	fs.BoolVar(&cfg.Debug, "debug", cfg.Debug, "log extra detail useful for tuning, such as per-connection send buffer high-water marks")
+	fs.BoolVar(&cfg.LogContent, "log-content", cfg.LogContent, "include message text in logs; otherwise only metadata such as length is logged")
+
+	fs.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "bearer token for admin endpoints (or CHAT_ADMIN_TOKEN); admin endpoints are disabled when empty")
+	fs.StringVar(&cfg.JWTSecret, "jwt-secret", cfg.JWTSecret, "HMAC secret for HS256 tokens (or CHAT_JWT_SECRET); enables JWT auth")
+	fs.StringVar(&cfg.JWTPublicKeyFile, "jwt-public-key", cfg.JWTPublicKeyFile, "path to a PEM RSA public key for RS256 tokens; enables JWT auth")
+	fs.StringVar(&cfg.JWTUsernameClaim, "jwt-username-claim", cfg.JWTUsernameClaim, "token claim holding the username")
+	fs.StringVar(&cfg.UsernameHeader, "username-header", cfg.UsernameHeader, "take the username from this header set by an authenticating proxy, e.g. X-Authenticated-User, ignoring the query string; only safe if clients can't bypass the proxy")
+	fs.Func("trusted-proxies", "comma-separated CIDRs of reverse proxies whose X-Forwarded-For is trusted", func(spec string) error {
+		for _, part := range strings.Split(spec, ",") {
+			p, err := netip.ParsePrefix(strings.TrimSpace(part))
+			if err != nil {
+				return fmt.Errorf("invalid proxy CIDR %q", part)
+			}
+			cfg.TrustedProxies = append(cfg.TrustedProxies, p.Masked())
+		}
+		return nil
+	})
+
+	fs.StringVar(&cfg.StaticDir, "static-dir", cfg.StaticDir, "serve the web UI from this directory (which must contain index.html) instead of the built-in page; custom UIs build their own WebSocket URL")
+	fs.BoolVar(&cfg.GzipResponses, "gzip", cfg.GzipResponses, "gzip the web page and JSON responses for clients that accept it")
+	fs.BoolVar(&cfg.GzipRequests, "gzip-requests", cfg.GzipRequests, "accept gzip-compressed REST request bodies (Content-Encoding: gzip)")
+
+	fs.StringVar(&cfg.RedisURL, "redis-url", cfg.RedisURL, "Redis URL (redis://host:port/db) for shared history and cross-instance broadcast")
+	fs.BoolVar(&cfg.StorageOptional, "storage-optional", cfg.StorageOptional, "start with in-memory history if the configured storage is unreachable, instead of exiting")
+	fs.IntVar(&cfg.StorageBuffer, "storage-buffer", cfg.StorageBuffer, "messages kept in memory while storage is unreachable, written once it is back (0 drops them)")
+	fs.StringVar(&cfg.NATSURL, "nats-url", cfg.NATSURL, "NATS server URL for relaying messages between instances, e.g. nats://localhost:4222 (used instead of Redis pub/sub)")
+	fs.StringVar(&cfg.AuditLog, "audit-log", cfg.AuditLog, "file that admin actions are appended to as JSON lines (kept in memory only when empty)")
+	fs.StringVar(&cfg.RoomACLFile, "room-acl", cfg.RoomACLFile, "file of room=user1,user2 lines; only listed users may join those rooms, other rooms stay open")
+	fs.StringVar(&cfg.DBKey, "db-key", cfg.DBKey, "base64 AES-GCM key (16, 24 or 32 bytes, or CHAT_DB_KEY) encrypting stored messages in rooms without a -room-keys key, and stored direct messages")
+	fs.StringVar(&cfg.RoomKeysFile, "room-keys", cfg.RoomKeysFile, "file of room=base64key lines; messages in those rooms are stored and relayed between instances encrypted with AES-GCM (16, 24 or 32 byte keys)")
+	fs.StringVar(&cfg.BotKeysFile, "bot-keys", cfg.BotKeysFile, "file of username=base64key lines registering bots' Ed25519 public keys; posts as those users must be signed")
+
+	fs.IntVar(&cfg.HistorySize, "history-size", cfg.HistorySize, "number of recent chat messages per room replayed to clients when they join (see -room-history)")
+	fs.Func("room-history", "per-room history sizes, e.g. general=200,private=0 (other rooms use -history-size)", func(spec string) error {
+		for _, part := range strings.Split(spec, ",") {
+			name, size, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok || !validRoomName(name) {
+				return fmt.Errorf("invalid room history entry %q", part)
+			}
+			n, err := strconv.Atoi(size)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid history size for room %s: %q", name, size)
+			}
+			cfg.RoomHistorySizes[name] = n
+		}
+		return nil
+	})
+	fs.IntVar(&cfg.Scrollback, "scrollback", cfg.Scrollback, "number of messages per room kept for clients paging back with fetch_history; rooms keep at least their history size")
+	fs.IntVar(&cfg.HistoryPageMax, "history-page-max", cfg.HistoryPageMax, "maximum number of messages a client can fetch with one fetch_history request")
+	fs.DurationVar(&cfg.ReplayMaxAge, "replay-max-age", cfg.ReplayMaxAge, "only replay history newer than this to joining clients (0 replays everything stored)")
+	fs.IntVar(&cfg.MaxConcurrentReplays, "max-concurrent-replays", cfg.MaxConcurrentReplays, "maximum number of clients receiving their history replay at once; others wait their turn (0 means unlimited)")
+	fs.IntVar(&cfg.InboxSize, "inbox-size", cfg.InboxSize, "most undelivered direct messages kept per offline user; the oldest are dropped (0 disables the inbox)")
+	fs.DurationVar(&cfg.InboxTTL, "inbox-ttl", cfg.InboxTTL, "how long an undelivered direct message waits in the recipient's inbox")
+	fs.IntVar(&cfg.MaxInboxes, "max-inboxes", cfg.MaxInboxes, "most offline users the in-memory store keeps direct messages for at once (0 means no limit)")
+
+	fs.IntVar(&cfg.MaxMessageRunes, "max-message-runes", cfg.MaxMessageRunes, "maximum length of message content in characters (Unicode code points)")
+	fs.IntVar(&cfg.MaxMetadataBytes, "max-metadata-bytes", cfg.MaxMetadataBytes, "maximum size of a message's JSON without its content, checked before broadcast (0 disables)")
+	fs.DurationVar(&cfg.MaxTTL, "max-ttl", cfg.MaxTTL, "longest lifetime a client may request for an ephemeral message")
+	fs.IntVar(&cfg.MaxPendingExpiry, "max-pending-expiry", cfg.MaxPendingExpiry, "maximum number of ephemeral messages waiting to expire")
+	fs.Func("empty-content", "what to do with messages whose content is only whitespace: reject or allow", func(s string) error {
+		switch s {
+		case emptyContentReject, emptyContentAllow:
+			cfg.EmptyContent = s
+			return nil
+		}
+		return fmt.Errorf("unknown policy %q", s)
+	})
+	fs.BoolVar(&cfg.SafeMarkdown, "safe-markdown", cfg.SafeMarkdown, "strip raw HTML and script-like link targets from markdown messages")
+	fs.BoolVar(&cfg.NormalizeTrim, "normalize-trim", cfg.NormalizeTrim, "trim leading and trailing whitespace from message content")
+	fs.BoolVar(&cfg.NormalizeBlankLines, "normalize-blank-lines", cfg.NormalizeBlankLines, "collapse runs of blank lines in message content into one")
+	fs.BoolVar(&cfg.NormalizeControl, "normalize-control", cfg.NormalizeControl, "strip control characters other than newlines and tabs from message content")
+	fs.BoolVar(&cfg.NormalizeNFC, "normalize-nfc", cfg.NormalizeNFC, "normalize message content to Unicode NFC")
+	fs.IntVar(&cfg.MaxPins, "max-pins", cfg.MaxPins, "maximum number of pinned messages per room")
+	fs.BoolVar(&cfg.MentionNotify, "mention-notify", cfg.MentionNotify, "notify mentioned users who are in another room or briefly disconnected")
+
+	fs.DurationVar(&cfg.PingInterval, "ping-interval", cfg.PingInterval, "how often to ping each client (0 disables keepalive pings)")
+	fs.DurationVar(&cfg.PongTimeout, "pong-timeout", cfg.PongTimeout, "how long to wait for a pong before dropping a client")
+	fs.BoolVar(&cfg.PushRTT, "push-rtt", cfg.PushRTT, "send each client its measured round-trip time after every pong")
+	fs.IntVar(&cfg.BatchMax, "batch-max", cfg.BatchMax, "most messages written in one frame to clients that opt in to batching with batch=true")
+	fs.DurationVar(&cfg.BatchDelay, "batch-delay", cfg.BatchDelay, "longest writePump waits for more messages to fill a batch")
+	fs.DurationVar(&cfg.ReconnectBackoff, "reconnect-backoff", cfg.ReconnectBackoff, "initial delay clients are told to wait before reconnecting")
+	fs.DurationVar(&cfg.PresenceInterval, "presence-interval", cfg.PresenceInterval, "how often batched presence updates are broadcast (0 sends every join/leave immediately)")
+	fs.IntVar(&cfg.PresenceBatchThreshold, "presence-batch-threshold", cfg.PresenceBatchThreshold, "number of clients in a room above which its presence updates are batched")
+	fs.DurationVar(&cfg.OutboxGrace, "outbox-grace", cfg.OutboxGrace, "how long to keep queuing room messages for a disconnected user (0 disables)")
+	fs.IntVar(&cfg.OutboxSize, "outbox-size", cfg.OutboxSize, "maximum messages kept per disconnected user")
+
+	fs.Func("unknown-room", "what to do when a client asks for a room that isn't configured: create, reject or redirect (to the default room)", func(s string) error {
+		switch s {
+		case roomPolicyCreate, roomPolicyReject, roomPolicyRedirect:
+			cfg.UnknownRoom = s
+			return nil
+		}
+		return fmt.Errorf("unknown policy %q", s)
+	})
+	fs.Func("rooms", "comma-separated rooms that exist besides the default room, for -unknown-room=reject or redirect", roomList(cfg.Rooms))
+	fs.DurationVar(&cfg.RoomIdleTimeout, "room-idle", cfg.RoomIdleTimeout, "evict rooms that have been empty and quiet for this long (0 keeps them forever)")
+	fs.IntVar(&cfg.MaxRooms, "max-rooms", cfg.MaxRooms, "maximum number of rooms; the least recently active empty room is evicted to make space (0 is unlimited)")
+	fs.DurationVar(&cfg.RoomArchiveAfter, "room-archive-after", cfg.RoomArchiveAfter, "archive rooms with no activity for this long; archived rooms keep their history and stay readable but reject new messages until unarchived (0 disables)")
+	fs.IntVar(&cfg.MaxArchivedRooms, "max-archived-rooms", cfg.MaxArchivedRooms, "maximum number of archived rooms kept after they leave memory; the longest archived is forgotten, with its history and pins, to make space (0 is unlimited)")
+	fs.Float64Var(&cfg.RoomRate, "room-rate", cfg.RoomRate, "maximum messages per second in a room across all senders, with bursts of up to a second's worth (0 disables; see -room-rates)")
+	fs.Func("room-rates", "per-room message rate limits in messages per second, e.g. general=50,announcements=1 (0 disables)", func(spec string) error {
+		for _, part := range strings.Split(spec, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok || !validRoomName(name) {
+				return fmt.Errorf("invalid room rate entry %q", part)
+			}
+			rate, err := strconv.ParseFloat(value, 64)
+			if err != nil || rate < 0 {
+				return fmt.Errorf("invalid rate for room %s: %q", name, value)
+			}
+			cfg.RoomRates[name] = rate
+		}
+		return nil
+	})
+	fs.Func("slow-mode", "per-room minimum interval between a user's messages, e.g. general=10s (0 disables)", func(spec string) error {
+		for _, part := range strings.Split(spec, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok || !validRoomName(name) {
+				return fmt.Errorf("invalid slow mode entry %q", part)
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return fmt.Errorf("invalid slow mode interval for room %s: %q", name, value)
+			}
+			cfg.SlowModes[name] = d
+		}
+		return nil
+	})
+	fs.Func("hidden-rooms", "comma-separated rooms left out of GET /api/rooms except for admins; they can still be joined by name", roomList(cfg.HiddenRooms))
+	fs.Func("room-format", "require messages in a room to follow a rule: room=link for a link in every message, or room=/regexp/ for content matching it, e.g. support=/^\\[(bug|question)\\] / (repeat for more rooms)", func(spec string) error {
+		name, value, ok := strings.Cut(spec, "=")
+		if !ok || !validRoomName(name) {
+			return fmt.Errorf("invalid room format %q", spec)
+		}
+		rule, err := ParseContentRule(value)
+		if err != nil {
+			return fmt.Errorf("room %s: %w", name, err)
+		}
+		cfg.RoomFormats[name] = rule
+		return nil
+	})
+
+	fs.IntVar(&cfg.MaxConnsPerIP, "max-conns-per-ip", cfg.MaxConnsPerIP, "maximum simultaneous WebSocket connections from one IP (0 means unlimited)")
+	fs.IntVar(&cfg.ChurnLimit, "churn-limit", cfg.ChurnLimit, "connections one IP may open within -churn-window before it is put in a cooldown; allow for clients sharing a NAT (0 disables)")
+	fs.DurationVar(&cfg.ChurnWindow, "churn-window", cfg.ChurnWindow, "window over which -churn-limit is counted")
+	fs.DurationVar(&cfg.ChurnCooldown, "churn-cooldown", cfg.ChurnCooldown, "first cooldown for an IP that reconnects too often; it doubles for each repeat, up to -churn-max-cooldown")
+	fs.DurationVar(&cfg.ChurnMaxCooldown, "churn-max-cooldown", cfg.ChurnMaxCooldown, "longest cooldown for an IP that keeps reconnecting")
+	fs.DurationVar(&cfg.StallTimeout, "stall-timeout", cfg.StallTimeout, "disconnect clients whose send buffer stays nearly full this long (0 disables)")
+	fs.DurationVar(&cfg.MaxConnLifetime, "max-connection-lifetime", cfg.MaxConnLifetime, "close connections older than this so clients reconnect and re-authenticate (0 disables)")
+	fs.Float64Var(&cfg.ShedAt, "shed-at", cfg.ShedAt, "start shedding load when the broadcast queue is this full, as a fraction of -hub-buffer (0 disables)")
+	fs.Float64Var(&cfg.ShedResume, "shed-resume", cfg.ShedResume, "stop shedding load once the broadcast queue has drained to this fraction of -hub-buffer")
+
+	fs.Func("sessions", "what to do when a user connects again while already connected: multiple (keep every session) or takeover (close the older ones)", func(s string) error {
+		switch s {
+		case sessionsMultiple, sessionsTakeover:
+			cfg.Sessions = s
+			return nil
+		}
+		return fmt.Errorf("unknown policy %q", s)
+	})
+	fs.Func("anonymous-names", "how to name users who connect without a username: friendly (e.g. brave-otter-42) or numbered (e.g. User417)", func(s string) error {
+		if _, ok := nameGenerators[s]; !ok {
+			return fmt.Errorf("unknown generator %q, want one of %s", s, strings.Join(slices.Sorted(maps.Keys(nameGenerators)), ", "))
+		}
+		cfg.AnonymousNames = s
+		return nil
+	})
+
+	fs.BoolVar(&cfg.Unfurl, "unfurl", cfg.Unfurl, "fetch title and OpenGraph previews for links in room messages and send them as unfurl events")
+	fs.Float64Var(&cfg.UnfurlRate, "unfurl-rate", cfg.UnfurlRate, "most link previews fetched per second across the server; links beyond that are skipped")
+	fs.Func("unfurl-allow", "comma-separated hosts link previews may be fetched from, subdomains included (default any public host)", hostList(&cfg.UnfurlAllow))
+	fs.Func("unfurl-deny", "comma-separated hosts link previews are never fetched from, subdomains included", hostList(&cfg.UnfurlDeny))
+}
+
+// roomList parses a comma-separated list of room names into set.
+func roomList(set map[string]bool) func(string) error {
+	return func(spec string) error {
+		for _, name := range strings.Split(spec, ",") {
+			name = strings.TrimSpace(name)
+			if !validRoomName(name) {
+				return fmt.Errorf("invalid room name %q", name)
+			}
+			set[name] = true
+		}
+		return nil
+	}
+}
+
+// hostList parses a comma-separated list of host names onto list.
+func hostList(list *[]string) func(string) error {
+	return func(spec string) error {
+		for _, host := range strings.Split(spec, ",") {
+			if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+				*list = append(*list, host)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,38 @@
+package chat
+
+import (
+	"flag"
+	"io"
+	"testing"
+	"time"
+)
+
+// TestRegisterFlagsZeroConfig parses every per-room flag into a Config that
+// didn't come from DefaultConfig.
+func TestRegisterFlagsZeroConfig(t *testing.T) {
+	tests := []struct {
+		args  []string
+		check func(Config) bool
+	}{
+		{[]string{"-room-history", "x=5"}, func(c Config) bool { return c.RoomHistorySizes["x"] == 5 }},
+		{[]string{"-rooms", "a,b"}, func(c Config) bool { return c.Rooms["a"] && c.Rooms["b"] }},
+		{[]string{"-room-rates", "x=2"}, func(c Config) bool { return c.RoomRates["x"] == 2 }},
+		{[]string{"-slow-mode", "x=10s"}, func(c Config) bool { return c.SlowModes["x"] == 10*time.Second }},
+		{[]string{"-hidden-rooms", "x"}, func(c Config) bool { return c.HiddenRooms["x"] }},
+		{[]string{"-room-format", "x=link"}, func(c Config) bool { _, ok := c.RoomFormats["x"]; return ok }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args[0], func(t *testing.T) {
+			var cfg Config
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			cfg.RegisterFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("%v didn't take: %+v", tt.args, cfg)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package chat
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+const (
+	formatPlain    = "plain"
+	formatMarkdown = "markdown"
+)
+
+var validFormats = map[string]bool{"": true, formatPlain: true, formatMarkdown: true}
+
+var (
+	htmlTag = regexp.MustCompile(`</?[a-zA-Z][a-zA-Z0-9-]*([\s/][^>]*)?>`)
+	// autolink is <scheme:...>, which renderers turn into a link.
+	autolink = regexp.MustCompile(`<[a-zA-Z][a-zA-Z0-9+.-]+:[^<>\x00-\x20]*>`)
+	// inlineLinkDest and refLinkDest end where a link's destination
+	// starts, in [text](dest) and in a "[label]: dest" definition.
+	inlineLinkDest = regexp.MustCompile(`\]\([ \t]*\n?[ \t]*`)
+	refLinkDest    = regexp.MustCompile(`(?m)^ {0,3}\[[^\]\n]+\]:[ \t]*\n?[ \t]*`)
+	// safeSchemes are the only schemes a link may use. Anything without a
+	// scheme is relative, and fine.
+	safeSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+)
+
+// sanitizeMarkdown removes the constructs a renderer could turn into active
+// content. The server never renders markdown itself; this only protects
+// clients whose renderer is less careful. Removing one tag can join the
+// pieces around it into another, so it repeats until nothing changes.
+func sanitizeMarkdown(s string) string {
+	for {
+		clean := defangLinks(s, inlineLinkDest)
+		clean = defangLinks(clean, refLinkDest)
+		clean = autolink.ReplaceAllStringFunc(clean, func(link string) string {
+			if safeLinkDest(link) {
+				return link
+			}
+			return ""
+		})
+		clean = htmlTag.ReplaceAllString(clean, "")
+		if clean == s {
+			return clean
+		}
+		s = clean
+	}
+}
+
+// defangLinks replaces every link destination found after a match of start
+// with "#" unless it is safe.
+func defangLinks(s string, start *regexp.Regexp) string {
+	var b strings.Builder
+	for {
+		loc := start.FindStringIndex(s)
+		if loc == nil {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:loc[1]])
+		s = s[loc[1]:]
+		n := linkDestLen(s)
+		if safeLinkDest(s[:n]) {
+			b.WriteString(s[:n])
+		} else {
+			b.WriteString("#")
+		}
+		s = s[n:]
+	}
+}
+
+// linkDestLen is the length of the link destination s starts with: either
+// <...> or a run without spaces, ending early at an unbalanced ")".
+func linkDestLen(s string) int {
+	if strings.HasPrefix(s, "<") {
+		if end := strings.IndexAny(s, ">\n"); end > 0 && s[end] == '>' {
+			return end + 1
+		}
+	}
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c <= ' ':
+			return i
+		case c == '\\':
+			i++
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return len(s)
+}
+
+// safeLinkDest reports whether a link destination has no scheme or a safe
+// one. It looks at the destination the way a renderer and then a browser
+// would: with entities decoded, escapes dropped, and whitespace and control
+// characters, which browsers ignore in a scheme, removed.
+func safeLinkDest(dest string) bool {
+	dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+	dest = strings.ReplaceAll(html.UnescapeString(dest), `\`, "")
+	dest = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, dest)
+	scheme, _, ok := strings.Cut(dest, ":")
+	if !ok || strings.ContainsAny(scheme, "/?#") {
+		return true
+	}
+	return safeSchemes[strings.ToLower(scheme)]
+}
+
+// applyFormat defaults a message to plain text and, in safe mode, cleans up
+// markdown.
+func (h *Hub) applyFormat(m *Message) {
+	if m.Format == "" {
+		m.Format = formatPlain
+	}
+	if m.Format == formatMarkdown && h.cfg.SafeMarkdown {
+		m.Content = sanitizeMarkdown(m.Content)
+	}
+}
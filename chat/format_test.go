@@ -0,0 +1,70 @@
+package chat
+
+import "testing"
+
+func TestSanitizeMarkdown(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain text", "hello *world*", "hello *world*"},
+		{"safe link", "[docs](https://example.com/a_(b))", "[docs](https://example.com/a_(b))"},
+		{"relative link", "[top](#top) [page](/help?x=1)", "[top](#top) [page](/help?x=1)"},
+		{"mailto", "[me](mailto:me@example.com)", "[me](mailto:me@example.com)"},
+		{"html tag", "a <b>bold</b> move", "a bold move"},
+		{"script tag", "<script>alert(1)</script>", "alert(1)"},
+		{"nested tags", "<<script>script>alert(1)<</script>/script>", "alert(1)"},
+		{"deeply nested tags", "<<<script>script>script>x", "x"},
+		{"javascript link", "[x](javascript:alert(1))", "[x](#)"},
+		{"mixed case scheme", "[x](JaVaScRiPt:alert(1))", "[x](#)"},
+		{"vbscript and data", "[a](vbscript:x) [b](data:text/html,x)", "[a](#) [b](#)"},
+		{"other schemes", "[x](file:///etc/passwd)", "[x](#)"},
+		{"angle brackets", "[x](<javascript:alert(1)>)", "[x](#)"},
+		{"angle brackets kept", "[x](<https://example.com/a b>)", "[x](<https://example.com/a b>)"},
+		{"title kept", `[x](javascript:alert(1) "hi")`, `[x](# "hi")`},
+		{"space before destination", "[x](  javascript:alert(1))", "[x](  #)"},
+		{"entity in scheme", "[x](&#106;avascript:alert(1))", "[x](#)"},
+		{"escape in scheme", `[x](javascript\:alert(1))`, "[x](#)"},
+		{"image", "![x](javascript:alert(1))", "![x](#)"},
+		{"reference definition", "[x][1]\n\n[1]: javascript:alert(1)", "[x][1]\n\n[1]: #"},
+		{"reference definition in angle brackets", "[x][1]\n\n[1]: <javascript:alert(1)>", "[x][1]\n\n[1]: #"},
+		{"reference definition on the next line", "[x][1]\n\n[1]:\n  javascript:alert(1)", "[x][1]\n\n[1]:\n  #"},
+		{"safe reference definition", "[x][1]\n\n[1]: https://example.com \"t\"", "[x][1]\n\n[1]: https://example.com \"t\""},
+		{"tag with slash", "<svg/onload=alert(1)>x", "x"},
+		{"safe autolink", "see <https://example.com>", "see <https://example.com>"},
+		{"unsafe autolink", "see <javascript:alert(1)>", "see "},
+		{"link built by removing a tag", "[x](java<b>script:alert(1))", "[x](#)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeMarkdown(tt.in); got != tt.want {
+				t.Errorf("sanitizeMarkdown(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		safe        bool
+		format      string
+		wantFormat  string
+		wantContent string
+	}{
+		{"defaults to plain", true, "", formatPlain, "<b>x</b>"},
+		{"plain left alone", true, formatPlain, formatPlain, "<b>x</b>"},
+		{"markdown cleaned in safe mode", true, formatMarkdown, formatMarkdown, "x"},
+		{"markdown left alone otherwise", false, formatMarkdown, formatMarkdown, "<b>x</b>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.SafeMarkdown = tt.safe
+			m := Message{Format: tt.format, Content: "<b>x</b>"}
+			NewHub(cfg).applyFormat(&m)
+			if m.Format != tt.wantFormat || m.Content != tt.wantContent {
+				t.Errorf("got format %q content %q, want %q %q", m.Format, m.Content, tt.wantFormat, tt.wantContent)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package chat
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		// Sniff from the plain bytes; net/http would otherwise sniff
+		// the compressed ones.
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.gz.Write(b)
+}
+
+// withGzip compresses a handler's response when the client accepts gzip.
+// It is not for streaming handlers or the WebSocket upgrade, which need the
+// raw connection.
+func (s *Server) withGzip(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.cfg.GzipResponses || !acceptsGzip(r) {
+			next(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		gz := gzip.NewWriter(w)
+		gw := &gzipResponseWriter{ResponseWriter: w, gz: gz}
+		next(gw, r)
+		if gw.wroteHeader {
+			gz.Close()
+		}
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(coding, "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// withGzipBody decompresses a request body sent with Content-Encoding: gzip
+// before the handler reads it. The decompressed body is capped like a plain
+// one, so a small upload can't inflate into a huge one.
+func (s *Server) withGzipBody(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.cfg.GzipRequests || !strings.EqualFold(strings.TrimSpace(r.Header.Get("Content-Encoding")), "gzip") {
+			next(w, r)
+			return
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "malformed gzip body", http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		r.Header.Del("Content-Encoding")
+		r.ContentLength = -1
+		r.Body = http.MaxBytesReader(w, gz, maxPostBodyBytes)
+		next(w, r)
+	}
+}
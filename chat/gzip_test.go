@@ -0,0 +1,117 @@
+package chat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestWithGzipBody(t *testing.T) {
+	const body = `{"room":"general","content":"hello"}`
+	tests := []struct {
+		name       string
+		enabled    bool
+		encoding   string
+		body       []byte
+		wantStatus int
+		wantBody   string
+	}{
+		{"plain", true, "", []byte(body), http.StatusOK, body},
+		{"gzip", true, "gzip", gzipped(t, body), http.StatusOK, body},
+		{"gzip in capitals", true, " GZIP ", gzipped(t, body), http.StatusOK, body},
+		{"malformed gzip", true, "gzip", []byte(body), http.StatusBadRequest, ""},
+		{"inflates past the cap", true, "gzip", gzipped(t, strings.Repeat("a", maxPostBodyBytes+1)), http.StatusRequestEntityTooLarge, ""},
+		{"disabled", false, "gzip", gzipped(t, body), http.StatusOK, string(gzipped(t, body))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.GzipRequests = tt.enabled
+			s := &Server{cfg: cfg}
+			echo := s.withGzipBody(func(w http.ResponseWriter, r *http.Request) {
+				data, err := io.ReadAll(r.Body)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+					return
+				}
+				w.Write(data)
+			})
+
+			req := httptest.NewRequest("POST", "/api/messages", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			rec := httptest.NewRecorder()
+			echo(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && rec.Body.String() != tt.wantBody {
+				t.Errorf("handler read %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestWithGzip(t *testing.T) {
+	const body = `{"rooms":["general"]}`
+	tests := []struct {
+		name     string
+		enabled  bool
+		accept   string
+		wantGzip bool
+	}{
+		{"accepts gzip", true, "gzip, deflate", true},
+		{"gzip refused", true, "gzip;q=0", false},
+		{"no gzip", true, "deflate", false},
+		{"disabled", false, "gzip", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.GzipResponses = tt.enabled
+			s := &Server{cfg: cfg}
+			h := s.withGzip(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, body)
+			})
+
+			req := httptest.NewRequest("GET", "/api/rooms", nil)
+			req.Header.Set("Accept-Encoding", tt.accept)
+			rec := httptest.NewRecorder()
+			h(rec, req)
+
+			got := rec.Body.Bytes()
+			if gotGzip := rec.Header().Get("Content-Encoding") == "gzip"; gotGzip != tt.wantGzip {
+				t.Fatalf("gzip = %v, want %v", gotGzip, tt.wantGzip)
+			}
+			if tt.wantGzip {
+				gz, err := gzip.NewReader(bytes.NewReader(got))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err = io.ReadAll(gz); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if string(got) != body {
+				t.Errorf("body = %q, want %q", got, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package chat
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// serveHealthz is the liveness probe: the process is up and serving HTTP.
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// serveReady is the readiness probe. It only passes once main has finished
+// connecting storage and the broker and the hub loop is running, and it
+// fails again as soon as shutdown starts.
+func serveReady(hub *Hub, ready *atomic.Bool, w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() || !hub.running.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ready\n"))
+}
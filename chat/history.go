@@ -0,0 +1,107 @@
+package chat
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+)
+
+// history is a fixed-size ring buffer of recent chat messages. It is owned by
+// the hub's run loop and is not safe for concurrent use.
+type history struct {
+	buf   []Message
+	start int
+	n     int
+}
+
+func newHistory(size int) *history {
+	if size < 0 {
+		size = 0
+	}
+	return &history{buf: make([]Message, size)}
+}
+
+func (h *history) add(m Message) {
+	if len(h.buf) == 0 {
+		return
+	}
+	if h.n < len(h.buf) {
+		h.buf[(h.start+h.n)%len(h.buf)] = m
+		h.n++
+		return
+	}
+	h.buf[h.start] = m
+	h.start = (h.start + 1) % len(h.buf)
+}
+
+// messages returns the buffered messages, oldest first.
+func (h *history) messages() []Message {
+	out := make([]Message, 0, h.n)
+	for i := 0; i < h.n; i++ {
+		out = append(out, h.buf[(h.start+i)%len(h.buf)])
+	}
+	return out
+}
+
+// remove drops the message with the given ID, keeping the rest in order.
+func (h *history) remove(id string) {
+	kept := h.messages()
+	kept = slices.DeleteFunc(kept, func(m Message) bool { return m.ID == id })
+	if len(kept) == h.n {
+		return
+	}
+	clear(h.buf)
+	copy(h.buf, kept)
+	h.start = 0
+	h.n = len(kept)
+}
+
+// recentEnough drops messages older than maxAge from a backlog. Imported
+// messages can carry old timestamps anywhere in it, so every one is checked.
+func recentEnough(backlog []Message, maxAge time.Duration) []Message {
+	if maxAge <= 0 {
+		return backlog
+	}
+	cutoff := time.Now().Add(-maxAge)
+	return slices.DeleteFunc(backlog, func(m Message) bool { return m.Timestamp.Before(cutoff) })
+}
+
+// defaultHistoryPage is the page size when a fetch_history request doesn't
+// give a limit.
+const defaultHistoryPage = 50
+
+// historyPage answers a fetch_history request with up to limit messages
+// from before the message with ID before, oldest first. An empty before
+// pages back from the newest message. Stores that keep scrollback are asked
+// for it; others can only page back through what they replay. It runs on
+// the storage goroutine.
+func (h *Hub) historyPage(s MessageStore, room, before string, limit int) (Message, error) {
+	if limit <= 0 {
+		limit = defaultHistoryPage
+	}
+	limit = min(limit, h.cfg.HistoryPageMax)
+	var messages []Message
+	var err error
+	if p, ok := s.(historyPager); ok {
+		messages, err = p.Before(room, before, limit)
+	} else if messages, err = s.Recent(room); err == nil {
+		messages, err = pageBefore(messages, before, limit)
+	}
+	if errors.Is(err, errNotInHistory) {
+		return Message{}, errorf("that message isn't in this room's history")
+	}
+	if err != nil {
+		log.Printf("History for #%s unavailable: %v", room, err)
+		return Message{}, errorf("history is unavailable right now, try again later")
+	}
+	return Message{
+		Type:      typeHistory,
+		Username:  systemUsername,
+		Room:      room,
+		Before:    before,
+		Limit:     limit,
+		History:   messages,
+		Timestamp: time.Now(),
+	}, nil
+}
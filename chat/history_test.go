@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// TestHistoryPageScrollback pages back past what a room replays, into its
+// scrollback.
+func TestHistoryPageScrollback(t *testing.T) {
+	tests := []struct {
+		name    string
+		room    string
+		before  string
+		limit   int
+		want    []string
+		wantErr bool
+	}{
+		{"newest page", defaultRoom, "", 3, []string{"7", "8", "9"}, false},
+		{"past the replay", defaultRoom, "7", 4, []string{"3", "4", "5", "6"}, false},
+		{"back to the oldest kept", defaultRoom, "4", 10, []string{"2", "3"}, false},
+		{"beyond the scrollback", defaultRoom, "", 20, []string{"2", "3", "4", "5", "6", "7", "8", "9"}, false},
+		{"unknown message", defaultRoom, "nope", 3, nil, true},
+		{"room without history", "quiet", "", 3, []string{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.HistorySize = 3
+			cfg.RoomHistorySizes = map[string]int{"quiet": 0}
+			cfg.Scrollback = 8
+			h := NewHub(cfg)
+			for i := range 10 {
+				for _, room := range []string{defaultRoom, "quiet"} {
+					h.store.Append(room, Message{ID: strconv.Itoa(i), Type: typeChat, Room: room})
+				}
+			}
+			if replay, _ := h.store.Recent(defaultRoom); len(replay) != cfg.HistorySize {
+				t.Fatalf("Recent returned %d messages, want the %d replayed", len(replay), cfg.HistorySize)
+			}
+
+			page, err := h.historyPage(h.store, tt.room, tt.before, tt.limit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("historyPage = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got := []string{}
+			for _, m := range page.History {
+				got = append(got, m.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("page = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
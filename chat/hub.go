@@ -0,0 +1,2343 @@
+// Package chat is a WebSocket chat server. Most programs run a Server, from
+// NewServer, which wires a Hub to HTTP routes; those wanting their own
+// routes can run a Hub from NewHub and hand it connections with ServeWS.
+package chat
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Message is the hub's view of a message. Its JSON form is defined in
+// wire.go, not here.
+type Message struct {
+	ID       string
+	Type     string
+	Username string
+	Room     string
+	To       string
+	// Target names the user a control message (mute, unmute) acts on.
+	Target string
+	// Priority messages jump the client's queue; see priority.go.
+	Priority  bool
+	ConnID    string
+	Content   string
+	Timestamp time.Time
+	// Platform is self-reported by the client and only used for analytics.
+	// Never trust it for authorization.
+	Platform string
+	// Online lists connected usernames on presence messages.
+	Online []string
+	// Mentions lists the known users @-mentioned in Content.
+	Mentions []string
+	// TTL, in seconds, makes a room message ephemeral: it is deleted for
+	// everyone at ExpiresAt and drops out of history replay.
+	TTL       int
+	ExpiresAt time.Time
+	RTTMillis int64
+	Color     string
+	AvatarURL string
+	Format    string
+	// Capabilities is only set on the connected handshake.
+	Capabilities *capabilities
+	// Before and Limit select a page of older messages for fetch_history,
+	// and History carries the page back.
+	Before  string
+	Limit   int
+	History []Message
+	// Verified marks a post signed with a registered bot key. Only the
+	// REST API sets it; readPump never copies it from a client.
+	Verified bool
+	// Sealed marks Content as encrypted by encryptedStore or
+	// encryptedBroker; see roomkeys.go. It only ever appears on stored
+	// and relayed records, never on what clients send or receive.
+	Sealed bool
+	// ClientMsgID is an opaque ID the sender picked, echoed back only to
+	// the connection that sent the message so it can match it up with
+	// what it already shows. The server never acts on it.
+	ClientMsgID string
+	// Preview is the link preview on an unfurl event, whose ID is the
+	// message it belongs to.
+	Preview *preview
+	// DisplayTime is Timestamp formatted for the receiving client, when it
+	// asked for one with tz. It is outgoing only.
+	DisplayTime string
+
+	// text, when set, lets write translate a system message.
+	text *localText
+	// received is when the message was handed to the hub on this
+	// instance, for the delivery latency metric.
+	received time.Time
+}
+
+const (
+	typeChat     = "chat"
+	typePresence = "presence"
+	typeWhoami   = "whoami"
+	typeMute     = "mute"
+	typeUnmute   = "unmute"
+	typeMention  = "mention"
+	typeDirect   = "dm"
+	typeSystem   = "system"
+	typeError    = "error"
+	typeDelete   = "delete"
+	typePin      = "pin"
+	typeUnpin    = "unpin"
+	typeLatency  = "latency"
+	// typeConnected is the first message on every connection; see
+	// connectedMessage.
+	typeConnected = "connected"
+	// typeFetchHistory asks for older messages; the reply is a
+	// typeHistory message.
+	typeFetchHistory = "fetch_history"
+	typeHistory      = "history"
+	typeUnfurl       = "unfurl"
+
+	systemUsername = "System"
+	defaultRoom    = "general"
+
+	sendBufferSize = 256
+)
+
+// command is a control message from a client that Run() answers privately
+// instead of broadcasting.
+type command struct {
+	client *Client
+	msg    Message
+}
+
+const unknownPlatform = "unknown"
+
+var allowedPlatforms = map[string]bool{
+	"web":    true,
+	"mobile": true,
+	"bot":    true,
+}
+
+func normalizePlatform(p string) string {
+	if allowedPlatforms[p] {
+		return p
+	}
+	return unknownPlatform
+}
+
+// Client is one WebSocket connection. Its state belongs to its pumps and
+// the hub, so nothing of it is exported.
+type Client struct {
+	id string
+	// conn is written to only by writePump, through writeMessage, apart
+	// from WriteControl, which gorilla allows from any goroutine. Anything
+	// else that needs to reach the client goes through send, priority or
+	// the hub.
+	conn     *websocket.Conn
+	username string
+	room     string
+	// spectator connections only watch: they can't post and don't show
+	// up in presence.
+	spectator bool
+	// admin connections presented the admin token, which lets them pin
+	// in any room.
+	admin bool
+	// batch clients take several messages per frame; see batch.go.
+	batch    bool
+	send     chan Message
+	priority chan Message
+	hub      *Hub
+	// overflow, once Run() has found priority full, carries every later
+	// priority message to waitPriority; see priority.go.
+	overflow chan Message
+
+	// muted holds usernames whose messages this connection doesn't want.
+	// Like the rest of the hub's state it's only touched from Run().
+	muted map[string]bool
+	// closed is set once Run() has closed send, so it is never closed
+	// twice and the client is never registered again.
+	closed bool
+	// writeDone is closed when writePump returns.
+	writeDone chan struct{}
+
+	// bytesIn and bytesOut count WebSocket payload bytes.
+	bytesIn, bytesOut atomic.Int64
+	// rtt is the rolling average ping round trip, in nanoseconds.
+	rtt atomic.Int64
+	// writing is set while writeMessage is writing a frame.
+	writing atomic.Bool
+
+	// resumeFrom is the last message ID the client saw before it
+	// reconnected; lastSent is only touched by writePump.
+	resumeFrom string
+	lastSent   string
+
+	// locale picks the language of system messages.
+	locale string
+	// displayZone, if set, is the time zone the client wants displayTime
+	// in.
+	displayZone *time.Location
+	// ip holds a slot in the hub's per-IP limit until writePump returns.
+	ip string
+	// stalledSince is when Run() first saw the send buffer nearly full.
+	stalledSince time.Time
+	// sendHWM is the fullest Run() has seen the send buffer.
+	sendHWM int
+	// closeErr, when set by Run() before it closes send, is why the
+	// client is being hung up on.
+	closeErr error
+	// color and avatarURL are the client's chosen look for this session,
+	// stamped on every message it sends.
+	color, avatarURL string
+	// connectedAt is when the client connected; past retireAt, if set,
+	// Run() makes it reconnect.
+	connectedAt, retireAt time.Time
+	// redirectedFrom is the unknown room the client asked for, when the
+	// unknown-room policy sent it to the default room instead.
+	redirectedFrom string
+	// replaying is set by Run() while the store is read for the client's
+	// backlog; messages for it meanwhile wait in held.
+	replaying bool
+	held      []Message
+	// releaseReplay gives back the client's replay slot once writePump
+	// has worked through the backlog; it is safe to call more than once.
+	releaseReplay func()
+	// replayed is closed by addClient once the whole backlog is queued.
+	replayed chan struct{}
+
+	unregisterOnce sync.Once
+}
+
+// Hub owns all client and room state. clients, users, rooms and outboxes are
+// only read or written by the Run goroutine; everything else talks to it through
+// the channels below, so none of it needs a lock.
+type Hub struct {
+	// cfg is the Config the hub was made with. Nothing changes it.
+	cfg Config
+
+	clients    map[*Client]bool
+	users      map[string][]*Client
+	broadcast  chan Message
+	register   chan *Client
+	unregister chan *Client
+	commands   chan command
+	notices    chan Message
+	remote     chan Message
+	inspect    chan chan []debugClient
+	ops        chan func()
+	done       chan struct{}
+	stopped    chan struct{}
+	stats      *hubStats
+	metrics    *hubMetrics
+	upgrader   websocket.Upgrader
+	startedAt  time.Time
+	events     *eventBus
+	rooms      map[string]*room
+	outboxes   map[string]*outbox
+
+	store MessageStore
+	// storage queues calls for the storage goroutine; see storage.go.
+	storage        chan func()
+	storageStopped chan struct{}
+	// inboxesSwept is when the store's inboxes were last swept.
+	inboxesSwept time.Time
+	// broker, when set, relays room broadcasts to and from other
+	// instances; seen drops messages that come back around.
+	broker   Broker
+	outgoing chan Message
+	seen     *idSet
+
+	presenceInterval       time.Duration
+	presenceBatchThreshold int
+
+	outboxGrace time.Duration
+	outboxSize  int
+
+	mentionNotify bool
+
+	expiring         expiryQueue
+	maxPendingExpiry int
+
+	roomIdleTimeout time.Duration
+	maxRooms        int
+	slowModes       map[string]time.Duration
+	roomRate        float64
+	archiveAfter    time.Duration
+	archived        map[string]time.Time
+	maxArchived     int
+	hidden          map[string]bool
+	roomRates       map[string]float64
+	roomFormats     map[string]ContentRule
+	ipConns         *connLimiter
+	churn           *churnTracker
+	unfurler        *unfurler
+	authorizeJoin   RoomAuthorizer
+	// roomACL is the -room-acl file, if there is one; the users it lists
+	// for a room may pin there.
+	roomACL       map[string]map[string]bool
+	generateName  NameGenerator
+	botKeys       map[string]ed25519.PublicKey
+	stallTimeout  time.Duration
+	maxLifetime   time.Duration
+	sessionPolicy string
+	pins          map[string][]pin
+	maxPins       int
+	motd          motd
+	replayMaxAge  time.Duration
+	replays       replayLimiter
+
+	draining atomic.Bool
+	// shedding is set while the hub turns away new work; see shed.go.
+	shedding           atomic.Bool
+	shedAt, shedResume float64
+	running            atomic.Bool
+	stopOnce           sync.Once
+}
+
+// NewHub makes a hub from cfg. It does nothing until Run is called.
+func NewHub(cfg Config) *Hub {
+	cfg = cfg.clone()
+	slowModes := cfg.SlowModes
+	if slowModes == nil {
+		slowModes = make(map[string]time.Duration)
+	}
+	hidden := cfg.HiddenRooms
+	if hidden == nil {
+		hidden = make(map[string]bool)
+	}
+	generateName := cfg.NameGenerator
+	if generateName == nil {
+		generateName = nameGenerators[cfg.AnonymousNames]
+	}
+	if generateName == nil {
+		generateName = friendlyName
+	}
+	authorizeJoin := cfg.RoomAuthorizer
+	if authorizeJoin == nil {
+		authorizeJoin = allowAllRooms
+	}
+	h := &Hub{
+		cfg:        cfg,
+		clients:    make(map[*Client]bool),
+		users:      make(map[string][]*Client),
+		broadcast:  make(chan Message, cfg.HubBuffer),
+		register:   make(chan *Client, cfg.HubBuffer),
+		unregister: make(chan *Client, cfg.HubBuffer),
+		commands:   make(chan command),
+		notices:    make(chan Message),
+		remote:     make(chan Message),
+		inspect:    make(chan chan []debugClient),
+		ops:        make(chan func()),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		stats:      newHubStats(),
+		upgrader: websocket.Upgrader{
+			HandshakeTimeout:  cfg.HandshakeTimeout,
+			EnableCompression: cfg.Compression,
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		},
+		startedAt: time.Now(),
+		events:    newEventBus(),
+		rooms:     make(map[string]*room),
+		outboxes:  make(map[string]*outbox),
+
+		store:          newMemoryStore(cfg.historySizes(), cfg.inboxLimits()),
+		storage:        make(chan func(), storageQueueSize),
+		storageStopped: make(chan struct{}),
+		seen:           newIDSet(seenIDsSize),
+
+		presenceInterval:       cfg.PresenceInterval,
+		presenceBatchThreshold: cfg.PresenceBatchThreshold,
+
+		outboxGrace: cfg.OutboxGrace,
+		outboxSize:  cfg.OutboxSize,
+
+		mentionNotify: cfg.MentionNotify,
+
+		maxPendingExpiry: cfg.MaxPendingExpiry,
+
+		roomIdleTimeout: cfg.RoomIdleTimeout,
+		maxRooms:        cfg.MaxRooms,
+		slowModes:       slowModes,
+		roomRate:        cfg.RoomRate,
+		archiveAfter:    cfg.RoomArchiveAfter,
+		archived:        make(map[string]time.Time),
+		maxArchived:     cfg.MaxArchivedRooms,
+		shedAt:          cfg.ShedAt,
+		shedResume:      cfg.ShedResume,
+		hidden:          hidden,
+		roomRates:       cfg.RoomRates,
+		roomFormats:     cfg.RoomFormats,
+		ipConns:         newConnLimiter(cfg.MaxConnsPerIP),
+		churn:           newChurnTracker(cfg.ChurnLimit, cfg.ChurnWindow, cfg.ChurnCooldown, cfg.ChurnMaxCooldown),
+		authorizeJoin:   authorizeJoin,
+		generateName:    generateName,
+		stallTimeout:    cfg.StallTimeout,
+		maxLifetime:     cfg.MaxConnLifetime,
+		sessionPolicy:   cfg.Sessions,
+		pins:            make(map[string][]pin),
+		maxPins:         cfg.MaxPins,
+		replayMaxAge:    cfg.ReplayMaxAge,
+		replays:         newReplayLimiter(cfg.MaxConcurrentReplays),
+	}
+	h.metrics = newHubMetrics(h)
+	return h
+}
+
+// flushRegistrations adds every client still waiting in register. A client
+// registers before its pumps start, so with a buffered register its first
+// message or its departure could otherwise be handled before its arrival. It
+// must only be called from Run().
+func (h *Hub) flushRegistrations() {
+	for len(h.register) > 0 {
+		h.addClient(<-h.register)
+	}
+}
+
+// Stop tells Run() to return, closing every client's connection on the
+// way out, and waits for it if it is running. It is safe to call more than
+// once.
+func (h *Hub) Stop() {
+	h.stopOnce.Do(func() { close(h.done) })
+	if h.running.Load() {
+		<-h.stopped
+	}
+}
+
+// do runs fn on the hub goroutine and waits for it to finish, so admin
+// handlers can change hub state safely. It reports false if the hub has
+// stopped.
+func (h *Hub) do(fn func()) bool {
+	done := make(chan struct{})
+	select {
+	case h.ops <- func() { fn(); close(done) }:
+	case <-h.done:
+		return false
+	}
+	<-done
+	return true
+}
+
+// injectRemote hands the hub a message broadcast on another instance.
+func (h *Hub) injectRemote(m Message) {
+	select {
+	case h.remote <- m:
+	case <-h.done:
+	}
+}
+
+// announce sends a message to every connected client in every room.
+func (h *Hub) announce(m Message) {
+	select {
+	case h.notices <- m:
+	case <-h.done:
+	}
+}
+
+// Run is the hub goroutine: it owns all hub state until Stop.
+func (h *Hub) Run() {
+	log.Println("Hub is running")
+	h.running.Store(true)
+	defer close(h.stopped)
+	defer h.running.Store(false)
+
+	var presenceTick <-chan time.Time
+	if h.presenceInterval > 0 {
+		ticker := time.NewTicker(h.presenceInterval)
+		defer ticker.Stop()
+		presenceTick = ticker.C
+	}
+
+	sweep := time.NewTicker(time.Second)
+	defer sweep.Stop()
+
+	go h.runStorage()
+	if h.broker != nil {
+		go h.publishLoop()
+	}
+
+	for {
+		select {
+		case client := <-h.register:
+			h.addClient(client)
+
+		case client := <-h.unregister:
+			h.flushRegistrations()
+			if _, ok := h.clients[client]; ok {
+				h.removeClient(client)
+				h.openOutbox(client)
+				log.Printf("Client %s (%s) unregistered. Total: %d", client.username, client.id, len(h.clients))
+				if r, ok := h.rooms[client.room]; ok {
+					r.touch()
+				}
+			}
+			// Clients the hub dropped itself, say for stalling or
+			// outliving -max-connection-lifetime, left the room then
+			// but only show up here once the connection is gone, so
+			// this is when presence catches up with them too.
+			if r, ok := h.rooms[client.room]; ok {
+				h.presenceChanged(r)
+			}
+
+		case message := <-h.broadcast:
+			h.flushRegistrations()
+			log.Printf("Broadcasting from %s (%s) to %d clients: %s", message.Username, message.ConnID, len(h.clients), h.logged(message))
+			if message.Type == typeDirect {
+				h.stats.recordMessage(message)
+				h.publish(eventMessage, message.Username, message.ConnID)
+				h.deliverDirect(message)
+				continue
+			}
+			r := h.room(message.Room)
+			if !h.archived[r.name].IsZero() {
+				h.replyError(message, roomArchivedError(r.name))
+				continue
+			}
+			if err := h.checkRoomFormat(message); err != nil {
+				h.replyError(message, err)
+				continue
+			}
+			if wait := h.checkSlowMode(r, message); wait > 0 {
+				h.replyError(message, slowModeError(r.name, wait))
+				continue
+			}
+			if !h.allowRoomMessage(r) {
+				h.replyError(message, roomBusyError(r.name))
+				continue
+			}
+			message.Mentions = h.resolveMentions(message.Content)
+			if message.TTL > 0 {
+				if err := h.scheduleExpiry(message); err != nil {
+					h.replyError(message, err)
+					continue
+				}
+			}
+			// Only now is the message accepted, so only now is it
+			// counted and announced.
+			h.stats.recordMessage(message)
+			h.publish(eventMessage, message.Username, message.ConnID)
+			// Queued before delivery, so a history read by anyone
+			// who has seen the message includes it.
+			h.storeMessage(r.name, message)
+			r.touch()
+			h.deliver(r, message)
+			// Replayed history isn't live delivery, so it stays out
+			// of the latency metric.
+			message.received = time.Time{}
+			message.ClientMsgID = ""
+			h.queueOutboxes(message)
+			h.unfurler.enqueue(message)
+			h.notifyMentions(message)
+			h.seen.add(message.ID)
+			h.relay(message)
+
+		case message := <-h.remote:
+			h.deliverRemote(message)
+
+		case cmd := <-h.commands:
+			h.flushRegistrations()
+			h.handleCommand(cmd)
+
+		case notice := <-h.notices:
+			for _, r := range h.rooms {
+				h.deliver(r, notice)
+			}
+
+		case now := <-sweep.C:
+			h.expireOutboxes()
+			h.expireMessages(now)
+			h.archiveIdleRooms(now)
+			h.evictIdleRooms(now)
+			h.dropStalledClients(now)
+			h.retireOldClients(now)
+			h.sweepInboxes(now)
+
+		case fn := <-h.ops:
+			fn()
+
+		case reply := <-h.inspect:
+			reply <- h.debugClients()
+
+		case <-presenceTick:
+			for _, r := range h.rooms {
+				if r.presenceDirty {
+					h.broadcastPresence(r)
+				}
+			}
+
+		case <-h.done:
+			for client := range h.clients {
+				h.removeClient(client)
+				client.conn.Close()
+			}
+			log.Println("Hub stopped")
+			return
+		}
+	}
+}
+
+// addClient registers a client in its room and replays the room's backlog.
+// It must only be called from Run().
+func (h *Hub) addClient(client *Client) {
+	if h.clients[client] || client.closed {
+		log.Printf("Ignoring duplicate registration of %s (%s)", client.username, client.id)
+		return
+	}
+	if !h.makeRoomFor(client.room) {
+		log.Printf("Rejecting %s (%s): room limit reached", client.username, client.id)
+		// The client was never added, so its queues are empty; send the
+		// reason and let writePump hang up after it.
+		err := wrapf(errRoomsFull, "the server has no room for #%s right now, try an existing room", client.room)
+		client.priority <- errorMessage(err)
+		client.closeErr = err
+		client.closed = true
+		close(client.send)
+		return
+	}
+
+	// The client is added now but replayed to once the store has
+	// answered. Its history read is queued behind every message stored
+	// so far, and whatever is broadcast meanwhile is held back for it, so
+	// nothing is missing from the backlog or overtakes it. A user back
+	// within the outbox grace period gets exactly what they missed instead
+	// of the usual backlog.
+	h.takeOverSessions(client)
+	_, existed := h.rooms[client.room]
+	r := h.room(client.room)
+	if !existed && !client.spectator && !h.permanentRoom(r.name) {
+		r.owner = client.username
+	}
+	r.touch()
+	h.clients[client] = true
+	client.retireAt = retireAt(client.connectedAt, h.maxLifetime)
+	h.addUserConn(client)
+	r.clients[client] = true
+	h.sendTo(client, h.connectedMessage(client))
+	client.replaying = true
+	backlog, resumed := h.takeOutbox(client)
+	var inbox []Message
+	h.storeThen(func(s MessageStore) error {
+		inbox = takeInbox(s, client.username)
+		if resumed {
+			return nil
+		}
+		var err error
+		backlog, err = s.Recent(r.name)
+		return err
+	}, func(err error) {
+		if err != nil {
+			log.Printf("History for #%s unavailable: %v", r.name, err)
+		}
+		if !resumed {
+			if client.resumeFrom != "" {
+				backlog = resumeAfter(backlog, client.resumeFrom)
+			}
+			backlog = recentEnough(backlog, h.replayMaxAge)
+		}
+		h.replay(client, backlog, inbox)
+	})
+	h.stats.setClients(len(h.clients), len(h.users))
+	log.Printf("Client %s (%s) registered in #%s. Total: %d", client.username, client.id, client.room, len(h.clients))
+	h.publish(eventConnect, client.username, client.id)
+	h.presenceChanged(r)
+}
+
+// replay sends a joining client its backlog and everything else it is owed
+// on arrival, then what was broadcast while the store was being read. It
+// runs in a later Run() step than addClient, once the storage goroutine has
+// answered; live messages from in between wait in client.held, which is what
+// keeps them behind the backlog. Anything else derived from stored history,
+// such as reaction counts if reactions are ever added, belongs here too, so
+// it lines up with the held live events the same way. It must only be called
+// from Run().
+func (h *Hub) replay(client *Client, backlog, inbox []Message) {
+	if client.closed {
+		// Gone before the store answered; its direct messages wait for
+		// the next connection.
+		h.restoreInbox(client.username, inbox)
+		return
+	}
+	client.replaying = false
+	for _, m := range backlog {
+		h.sendTo(client, m)
+	}
+	for _, p := range h.pins[client.room] {
+		h.sendTo(client, p.event(typePin))
+	}
+	for _, m := range inbox {
+		h.sendTo(client, m)
+	}
+	if client.redirectedFrom != "" {
+		h.sendTo(client, systemMessage(typeSystem, "#%s doesn't exist, so you've joined #%s", client.redirectedFrom, client.room))
+	}
+	if now := time.Now(); h.motd.active(now) {
+		h.sendTo(client, h.motd.message(now))
+	}
+	close(client.replayed)
+	held := client.held
+	client.held = nil
+	for _, m := range held {
+		h.sendTo(client, m)
+	}
+}
+
+// deliver fans a message out to every client in a room, dropping any whose
+// send buffer is full. It must only be called from Run().
+func (h *Hub) deliver(r *room, message Message) {
+	for client := range r.clients {
+		if client.muted[message.Username] {
+			continue
+		}
+		if !h.push(client, message) {
+			continue
+		}
+		log.Printf("Sent to %s (%s)", client.username, client.id)
+		if slices.Contains(message.Mentions, client.username) {
+			// The chat itself stays in order behind whatever is already
+			// queued; only a bare pointer to it jumps the queue.
+			h.push(client, Message{
+				ID:        message.ID,
+				Type:      typeMention,
+				Username:  message.Username,
+				Room:      message.Room,
+				Timestamp: message.Timestamp,
+				Priority:  true,
+			})
+		}
+	}
+}
+
+// push queues a message for a client, dropping the client if it can't keep
+// up. It must only be called from Run().
+func (h *Hub) push(client *Client, message Message) bool {
+	if client.id != message.ConnID {
+		message.ClientMsgID = ""
+	}
+	if message.Priority {
+		return h.sendPriority(client, message)
+	}
+	if client.replaying {
+		// Held back until the backlog has been sent, within the same
+		// limit the send buffer would have set.
+		if len(client.held) >= cap(client.send) {
+			h.removeClient(client)
+			return false
+		}
+		client.held = append(client.held, message)
+		return true
+	}
+	select {
+	case client.send <- message:
+		client.noteSendDepth()
+		return true
+	default:
+		h.removeClient(client)
+		return false
+	}
+}
+
+// sendTo delivers a message to a single client, dropping the client if its
+// send buffer is full. It must only be called from Run().
+func (h *Hub) sendTo(client *Client, message Message) {
+	if !h.clients[client] {
+		return
+	}
+	h.push(client, message)
+}
+
+// removeClient drops a registered client and closes its send channel, which
+// tells its writePump to hang up. It must only be called from Run().
+func (h *Hub) removeClient(client *Client) {
+	if client.closed {
+		return
+	}
+	client.closed = true
+	delete(h.clients, client)
+	h.removeUserConn(client)
+	if r, ok := h.rooms[client.room]; ok {
+		delete(r.clients, client)
+	}
+	close(client.send)
+	h.stats.setClients(len(h.clients), len(h.users))
+	h.stats.recordSendHWM(sendHWMBucket(client.sendHWM, cap(client.send)))
+	h.debugf("Send buffer high-water mark for %s (%s): %d/%d", client.username, client.id, client.sendHWM, cap(client.send))
+	h.publish(eventDisconnect, client.username, client.id)
+}
+
+func (h *Hub) publish(typ, username, connID string) {
+	h.events.publish(event{
+		Type:      typ,
+		Username:  username,
+		ConnID:    connID,
+		Clients:   len(h.clients),
+		Timestamp: time.Now(),
+	})
+}
+
+// deliverRemote fans out a room message that another instance has already
+// stored, so it is only delivered locally.
+func (h *Hub) deliverRemote(message Message) {
+	if !h.seen.add(message.ID) {
+		return
+	}
+	if message.TTL > 0 {
+		if err := h.scheduleExpiry(message); err != nil {
+			log.Printf("Not expiring relayed message %s: %v", message.ID, err)
+		}
+	}
+	if r, ok := h.rooms[message.Room]; ok {
+		r.touch()
+		h.deliver(r, message)
+	}
+	h.queueOutboxes(message)
+	h.notifyMentions(message)
+}
+
+// replyError sends an error back to the connection that sent message.
+func (h *Hub) replyError(message Message, err error) {
+	for _, client := range h.users[message.Username] {
+		if client.id == message.ConnID {
+			h.sendTo(client, errorMessage(err))
+			return
+		}
+	}
+}
+
+func (h *Hub) handleCommand(cmd command) {
+	switch cmd.msg.Type {
+	case typeError, typeLatency:
+		h.sendTo(cmd.client, cmd.msg)
+	case typeWhoami:
+		h.sendTo(cmd.client, Message{
+			Type:      typeWhoami,
+			Username:  cmd.client.username,
+			Room:      cmd.client.room,
+			ConnID:    cmd.client.id,
+			Timestamp: time.Now(),
+		})
+	case typeMute, typeUnmute:
+		format := "Muted %s"
+		if cmd.msg.Type == typeMute {
+			if !cmd.client.muted[cmd.msg.Target] && len(cmd.client.muted) >= maxMutes {
+				h.sendTo(cmd.client, errorMessage(errorf("you've already muted %d people; unmute someone first", maxMutes)))
+				return
+			}
+			cmd.client.muted[cmd.msg.Target] = true
+		} else {
+			delete(cmd.client.muted, cmd.msg.Target)
+			format = "Unmuted %s"
+		}
+		h.sendTo(cmd.client, systemMessage(typeSystem, format, cmd.msg.Target))
+	case typePin, typeUnpin:
+		if err := h.togglePin(cmd.client, cmd.msg.Type, cmd.msg.ID); err != nil {
+			h.sendTo(cmd.client, errorMessage(err))
+		}
+	case typeFetchHistory:
+		var page Message
+		h.storeThen(func(s MessageStore) error {
+			var err error
+			page, err = h.historyPage(s, cmd.client.room, cmd.msg.Before, cmd.msg.Limit)
+			return err
+		}, func(err error) {
+			if errors.Is(err, errStorageBusy) {
+				err = errorf("history is unavailable right now, try again later")
+			}
+			if err != nil {
+				page = errorMessage(err)
+			}
+			h.sendTo(cmd.client, page)
+		})
+	}
+}
+
+// leave asks the hub to drop the client. Both pumps call it when they stop,
+// so it only ever sends once.
+func (c *Client) leave() {
+	c.unregisterOnce.Do(func() {
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
+	})
+}
+
+func (c *Client) readPump(ctx context.Context) {
+	readPumps.Add(1)
+	defer readPumps.Add(-1)
+	defer func() {
+		c.leave()
+		c.conn.Close()
+	}()
+
+	// Unblock ReadJSON once the server starts shutting down.
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	if c.hub.cfg.PingInterval > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.hub.cfg.PongTimeout))
+		c.conn.SetPongHandler(c.handlePong(func() bool { return ctx.Err() != nil }))
+	}
+
+	for {
+		var msg Message
+		_, data, err := c.conn.ReadMessage()
+		if err == nil {
+			c.countIn(len(data))
+			err = json.Unmarshal(data, &msg)
+		}
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+			// The frame was read in full, so the connection is still
+			// usable; tell the client instead of hanging up.
+			log.Printf("Malformed JSON from %s (%s): %v", c.username, c.id, err)
+			if !c.replyError(fmt.Errorf("malformed JSON: %w", err)) {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			var netErr net.Error
+			switch {
+			case errors.Is(err, websocket.ErrReadLimit):
+				// gorilla has already sent the close frame.
+				log.Printf("Oversized frame from %s (%s): %v: limit is %d bytes", c.username, c.id, errMessageTooLong, c.hub.cfg.MaxFrameBytes)
+			case ctx.Err() != nil:
+				log.Printf("Stopped reading from %s (%s): server shutting down", c.username, c.id)
+			case c.hub.cfg.PingInterval > 0 && errors.As(err, &netErr) && netErr.Timeout():
+				// The pong handler keeps pushing the deadline out, so
+				// this is a dead connection rather than a quiet one.
+				log.Printf("Dropping %s (%s): no pong for %v", c.username, c.id, c.hub.cfg.PongTimeout)
+			default:
+				log.Printf("Read error from %s (%s): %v", c.username, c.id, err)
+			}
+			break
+		}
+
+		// Content that normalizes to nothing fails validation below.
+		msg.Content = c.hub.normalizeContent(msg.Content)
+		if err := c.hub.validate(msg); err != nil {
+			log.Printf("Rejected message from %s (%s): %v", c.username, c.id, err)
+			if !c.replyError(err) {
+				return
+			}
+			continue
+		}
+
+		if c.spectator && !controlTypes[msg.Type] {
+			if !c.replyError(errSpectator) {
+				return
+			}
+			continue
+		}
+		c.hub.metrics.countMessage(msg)
+
+		if controlTypes[msg.Type] {
+			if !c.command(msg) {
+				return
+			}
+			continue
+		}
+
+		if c.hub.overloaded() {
+			if !c.replyError(serverBusyError()) {
+				return
+			}
+			continue
+		}
+
+		msg = c.outgoing(msg)
+		c.hub.applyFormat(&msg)
+		if err := c.hub.checkMetadataSize(msg); err != nil {
+			log.Printf("Rejected message from %s (%s): %v", c.username, c.id, err)
+			if !c.replyError(err) {
+				return
+			}
+			continue
+		}
+		log.Printf("Received from %s (%s): %s", c.username, c.id, c.hub.logged(msg))
+		msg.received = time.Now()
+
+		select {
+		case c.hub.broadcast <- msg:
+		case <-c.hub.done:
+			return
+		}
+	}
+}
+
+// outgoing builds the message to broadcast from what a client sent. Only
+// the fields a client may set are copied; the rest are the server's to fill
+// in, so a client can't forge a preview, a presence list or a verified post.
+func (c *Client) outgoing(in Message) Message {
+	m := Message{
+		ID:          newID(),
+		Type:        typeChat,
+		Username:    c.username,
+		Room:        c.room,
+		ConnID:      c.id,
+		Content:     in.Content,
+		Timestamp:   time.Now(),
+		Platform:    normalizePlatform(in.Platform),
+		TTL:         in.TTL,
+		Color:       c.color,
+		AvatarURL:   c.avatarURL,
+		Format:      in.Format,
+		ClientMsgID: in.ClientMsgID,
+	}
+	if in.Type == typeDirect {
+		m.Type = typeDirect
+		m.Room = ""
+		m.To = in.To
+		m.TTL = 0
+	}
+	if m.TTL > 0 {
+		m.ExpiresAt = m.Timestamp.Add(time.Duration(m.TTL) * time.Second)
+	}
+	return m
+}
+
+var errSpectator = errors.New("spectators can't send messages")
+
+// command hands a message to Run() for a private response. It returns false
+// if the hub has stopped.
+func (c *Client) command(msg Message) bool {
+	select {
+	case c.hub.commands <- command{client: c, msg: msg}:
+		return true
+	case <-c.hub.done:
+		return false
+	}
+}
+
+// replyError tells this client, and only this client, why its last message
+// was not accepted.
+func (c *Client) replyError(err error) bool {
+	return c.command(errorMessage(err))
+}
+
+func (c *Client) writePump() {
+	writePumps.Add(1)
+	defer writePumps.Add(-1)
+	defer close(c.writeDone)
+	defer c.hub.ipConns.release(c.ip)
+	defer c.conn.Close()
+	defer c.releaseReplay()
+
+	var pings <-chan time.Time
+	if c.hub.cfg.PingInterval > 0 {
+		ticker := time.NewTicker(c.hub.cfg.PingInterval)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+	replayed := c.replayed
+
+	for {
+		// Anything urgent goes out before the next ordinary message.
+		select {
+		case message := <-c.priority:
+			if !c.write(message) {
+				return
+			}
+			continue
+		default:
+		}
+
+		select {
+		case message := <-c.priority:
+			if !c.write(message) {
+				return
+			}
+		case <-c.hub.done:
+			// A client still queued in register when the hub stopped
+			// will never have send closed, so say goodbye here.
+			c.writeMessage(websocket.CloseMessage, closeMessage(errShuttingDown))
+			return
+		case <-replayed:
+			// Stop waiting on it; from here on an empty queue means the
+			// backlog is out, however quiet the room.
+			replayed = nil
+			if len(c.send) == 0 {
+				c.releaseReplay()
+			}
+		case <-pings:
+			if err := c.ping(); err != nil {
+				log.Printf("Ping error to %s (%s): %v", c.username, c.id, err)
+				c.leave()
+				return
+			}
+		case message, ok := <-c.send:
+			if !ok {
+				c.hangUp()
+				return
+			}
+			if c.batch {
+				batch, closed := c.collect(message)
+				if !c.writeBatch(batch) {
+					return
+				}
+				if closed {
+					c.hangUp()
+					return
+				}
+			} else if !c.write(message) {
+				return
+			}
+			if replayed == nil && len(c.send) == 0 {
+				// The backlog queued at registration has gone out.
+				c.releaseReplay()
+			}
+		}
+	}
+}
+
+// hangUp sends the close frame once Run() has closed send.
+func (c *Client) hangUp() {
+	frame := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, closeReason(c.hub.cfg.ReconnectBackoff, c.lastSent))
+	switch {
+	case errors.Is(c.closeErr, errReconnectRequired):
+		// The client is welcome back straight away.
+		frame = websocket.FormatCloseMessage(closeCode(c.closeErr), closeReason(c.hub.cfg.ReconnectBackoff, c.lastSent))
+	case c.closeErr != nil:
+		frame = closeMessage(c.closeErr)
+	}
+	c.writeMessage(websocket.CloseMessage, frame)
+}
+
+var errConcurrentWrite = errors.New("concurrent write: only writePump may write to a connection")
+
+// writeMessage is how writePump writes data and close frames. Two writes at
+// once would interleave on the wire, so the second one closes the
+// connection instead: that client reconnects, and nobody else is affected.
+func (c *Client) writeMessage(messageType int, data []byte) error {
+	if !c.writing.CompareAndSwap(false, true) {
+		log.Printf("Closing %s (%s): %v", c.username, c.id, errConcurrentWrite)
+		c.conn.Close()
+		return errConcurrentWrite
+	}
+	defer c.writing.Store(false)
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// localize puts a system message into the client's language.
+func (c *Client) localize(message Message) Message {
+	if message.text != nil {
+		message.Content = message.text.in(c.locale)
+	}
+	if c.displayZone != nil {
+		message.DisplayTime = c.displayTime(message.Timestamp)
+		if message.History != nil {
+			history := make([]Message, len(message.History))
+			for i, m := range message.History {
+				m.DisplayTime = c.displayTime(m.Timestamp)
+				history[i] = m
+			}
+			message.History = history
+		}
+	}
+	return message
+}
+
+// sent notes a message as delivered, for resuming and the latency metric.
+func (c *Client) sent(message Message) {
+	if message.ID != "" && message.Room != "" && message.Type == typeChat {
+		c.lastSent = message.ID
+	}
+	c.hub.metrics.observeDelivery(message.received)
+}
+
+func (c *Client) write(message Message) bool {
+	data, err := json.Marshal(c.localize(message))
+	if err == nil {
+		err = c.writeFrame(data)
+	}
+	if err != nil {
+		log.Printf("Write error to %s (%s): %v", c.username, c.id, err)
+		// Don't wait for readPump to notice; it may sit idle for a long
+		// time on a half-dead connection.
+		c.leave()
+		return false
+	}
+	c.sent(message)
+	c.countOut(len(data))
+	log.Printf("Sent message to %s (%s)", c.username, c.id)
+	return true
+}
+
+// ServeWS upgrades a request to a WebSocket and joins the connection to hub,
+// for programs that run a Hub without a Server. The username comes from the
+// query string or -username-header; JWT auth is only available via Server.
+// Cancelling ctx closes the connection.
+func ServeWS(ctx context.Context, hub *Hub, w http.ResponseWriter, r *http.Request) {
+	serveWS(ctx, hub, nil, w, r)
+}
+
+func serveWS(ctx context.Context, hub *Hub, auth *tokenAuth, w http.ResponseWriter, r *http.Request) {
+	if hub.draining.Load() {
+		http.Error(w, "server is draining", http.StatusServiceUnavailable)
+		return
+	}
+	if hub.overloaded() {
+		http.Error(w, serverBusyError().Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	var username string
+	if auth != nil {
+		name, err := auth.username(r.URL.Query().Get("token"))
+		if err != nil {
+			log.Printf("Auth error: %v", err)
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		username = name
+	} else if hub.cfg.UsernameHeader != "" {
+		name, err := headerUsername(hub.cfg.UsernameHeader, hub.cfg.TrustedProxies, r)
+		if err != nil {
+			log.Printf("Rejecting connection from %s: %v", r.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		username = name
+	} else {
+		username = r.URL.Query().Get("username")
+		if username == "" && !hub.do(func() { username = hub.anonymousName() }) {
+			http.Error(w, "hub stopped", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	room := r.URL.Query().Get("room")
+	if room == "" {
+		room = defaultRoom
+	}
+	if !validRoomName(room) {
+		http.Error(w, "invalid room name", http.StatusBadRequest)
+		return
+	}
+	requested := room
+	room, redirected, roomErr := hub.resolveRoom(requested)
+	if roomErr == nil && !hub.authorizeJoin(username, room, r) {
+		roomErr = fmt.Errorf("%w: #%s", errRoomForbidden, room)
+	}
+
+	spectator := r.URL.Query().Get("spectator") == "true"
+	batch := r.URL.Query().Get("batch") == "true"
+	color, avatarURL, err := parseProfile(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	displayZone, err := parseDisplayZone(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resume := r.URL.Query().Get("resume")
+	lang := r.URL.Query().Get("lang")
+	if lang == "" {
+		lang = r.Header.Get("Accept-Language")
+	}
+
+	ip := clientIP(hub.cfg.TrustedProxies, r)
+	if !hub.ipConns.acquire(ip) {
+		log.Printf("Rejecting connection from %s: too many open connections", ip)
+		http.Error(w, "too many connections from your address", http.StatusTooManyRequests)
+		return
+	}
+
+	conn, err := hub.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		hub.ipConns.release(ip)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			log.Printf("Handshake timeout from %s after %v", r.RemoteAddr, hub.upgrader.HandshakeTimeout)
+		} else {
+			log.Printf("Upgrade error: %v", err)
+		}
+		return
+	}
+
+	if roomErr != nil {
+		log.Printf("Rejecting %s: %v", username, roomErr)
+		hub.ipConns.release(ip)
+		rejectJoin(conn, roomErr)
+		return
+	}
+	// Checked after the upgrade so the client hears how long to wait.
+	if wait := hub.churn.connect(ip, time.Now()); wait > 0 {
+		hub.ipConns.release(ip)
+		rejectChurn(conn, wait)
+		return
+	}
+
+	conn.SetReadLimit(hub.cfg.MaxFrameBytes)
+
+	client := &Client{
+		id:          newID(),
+		hub:         hub,
+		conn:        conn,
+		username:    username,
+		room:        room,
+		spectator:   spectator,
+		admin:       isAdmin(hub.cfg.AdminToken, r),
+		batch:       batch,
+		resumeFrom:  resume,
+		locale:      parseLocale(lang),
+		displayZone: displayZone,
+		ip:          ip,
+		color:       color,
+		avatarURL:   avatarURL,
+		muted:       make(map[string]bool),
+		send:        make(chan Message, sendBufferSize),
+		priority:    make(chan Message, priorityBufferSize),
+		writeDone:   make(chan struct{}),
+		replayed:    make(chan struct{}),
+		connectedAt: time.Now(),
+	}
+	if redirected {
+		client.redirectedFrom = requested
+	}
+
+	// The hub may stop, or every slot stay taken, while we wait; hang up
+	// with a reason rather than leave the client with a dead connection.
+	if err := hub.replays.acquire(hub.done, replayWait); err != nil {
+		log.Printf("Rejecting %s: %v", username, err)
+		hub.ipConns.release(ip)
+		rejectJoin(conn, err)
+		return
+	}
+	client.releaseReplay = sync.OnceFunc(hub.replays.release)
+
+	select {
+	case client.hub.register <- client:
+	case <-client.hub.done:
+		client.releaseReplay()
+		hub.ipConns.release(ip)
+		rejectJoin(conn, errShuttingDown)
+		return
+	}
+
+	go client.writePump()
+	go client.readPump(ctx)
+}
+
+func serveHome(w http.ResponseWriter, r *http.Request) {
+	html := `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>WorkChat Pro</title>
+    <link href="https://cdnjs.cloudflare.com/ajax/libs/font-awesome/6.4.0/css/all.min.css" rel="stylesheet">
+    <style>
+        * {
+            margin: 0;
+            padding: 0;
+            box-sizing: border-box;
+        }
+
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, sans-serif;
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            height: 100vh;
+            overflow: hidden;
+        }
+
+        .chat-container {
+            display: flex;
+            height: 100vh;
+            background: white;
+            margin: 20px;
+            border-radius: 16px;
+            box-shadow: 0 20px 60px rgba(0, 0, 0, 0.15);
+            overflow: hidden;
+        }
+
+        /* Sidebar */
+        .sidebar {
+            width: 280px;
+            background: linear-gradient(180deg, #2c3e50 0%, #34495e 100%);
+            display: flex;
+            flex-direction: column;
+            border-radius: 16px 0 0 16px;
+        }
+
+        .logo {
+            padding: 24px;
+            border-bottom: 1px solid rgba(255, 255, 255, 0.1);
+        }
+
+        .logo h1 {
+            color: white;
+            font-size: 24px;
+            font-weight: 700;
+            display: flex;
+            align-items: center;
+            gap: 12px;
+        }
+
+        .logo i {
+            color: #3498db;
+            font-size: 28px;
+        }
+
+        .user-info {
+            padding: 20px 24px;
+            border-bottom: 1px solid rgba(255, 255, 255, 0.1);
+        }
+
+        .user-avatar {
+            width: 48px;
+            height: 48px;
+            border-radius: 50%;
+            background: linear-gradient(135deg, #3498db, #2980b9);
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            color: white;
+            font-weight: bold;
+            font-size: 18px;
+            margin-bottom: 12px;
+        }
+
+        .user-name {
+            color: white;
+            font-weight: 600;
+            font-size: 16px;
+            margin-bottom: 4px;
+        }
+
+        .user-status {
+            display: flex;
+            align-items: center;
+            gap: 8px;
+            color: #95a5a6;
+            font-size: 14px;
+        }
+
+        .status-dot {
+            width: 8px;
+            height: 8px;
+            border-radius: 50%;
+            background: #2ecc71;
+            animation: pulse 2s infinite;
+        }
+
+        @keyframes pulse {
+            0% { transform: scale(1); opacity: 1; }
+            50% { transform: scale(1.2); opacity: 0.8; }
+            100% { transform: scale(1); opacity: 1; }
+        }
+
+        .channels {
+            flex: 1;
+            padding: 20px 0;
+        }
+
+        .channel-header {
+            padding: 0 24px 12px;
+            color: #95a5a6;
+            font-size: 12px;
+            font-weight: 600;
+            text-transform: uppercase;
+            letter-spacing: 1px;
+        }
+
+        .channel {
+            padding: 12px 24px;
+            color: #bdc3c7;
+            cursor: pointer;
+            transition: all 0.3s ease;
+            display: flex;
+            align-items: center;
+            gap: 12px;
+        }
+
+        .channel:hover {
+            background: rgba(255, 255, 255, 0.1);
+            color: white;
+        }
+
+        .channel.active {
+            background: rgba(52, 152, 219, 0.2);
+            color: #3498db;
+            border-right: 3px solid #3498db;
+        }
+
+        .online-user {
+            padding: 8px 24px;
+            color: #bdc3c7;
+            font-size: 14px;
+            display: flex;
+            align-items: center;
+            gap: 12px;
+        }
+
+        .online-user .status-dot {
+            animation: none;
+        }
+
+        /* Main Chat Area */
+        .main-content {
+            flex: 1;
+            display: flex;
+            flex-direction: column;
+            background: #f8f9fa;
+        }
+
+        /* Chat Header */
+        .chat-header {
+            background: white;
+            padding: 20px 32px;
+            border-bottom: 1px solid #e9ecef;
+            display: flex;
+            align-items: center;
+            justify-content: space-between;
+        }
+
+        .chat-title {
+            display: flex;
+            align-items: center;
+            gap: 12px;
+        }
+
+        .chat-title h2 {
+            color: #2c3e50;
+            font-size: 20px;
+            font-weight: 600;
+        }
+
+        .chat-title .channel-icon {
+            color: #6c757d;
+        }
+
+        .chat-actions {
+            display: flex;
+            gap: 16px;
+        }
+
+        .action-btn {
+            background: none;
+            border: none;
+            color: #6c757d;
+            font-size: 18px;
+            cursor: pointer;
+            padding: 8px;
+            border-radius: 50%;
+            transition: all 0.3s ease;
+        }
+
+        .action-btn:hover {
+            background: #f1f3f4;
+            color: #495057;
+        }
+
+        /* Messages Area */
+        .messages-container {
+            flex: 1;
+            overflow-y: auto;
+            padding: 24px 32px;
+            scroll-behavior: smooth;
+        }
+
+        .message {
+            display: flex;
+            margin-bottom: 24px;
+            animation: messageSlide 0.3s ease-out;
+        }
+
+        @keyframes messageSlide {
+            from {
+                opacity: 0;
+                transform: translateY(20px);
+            }
+            to {
+                opacity: 1;
+                transform: translateY(0);
+            }
+        }
+
+        .message-avatar {
+            width: 40px;
+            height: 40px;
+            border-radius: 50%;
+            background: linear-gradient(135deg, #e74c3c, #c0392b);
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            color: white;
+            font-weight: bold;
+            margin-right: 12px;
+            flex-shrink: 0;
+        }
+
+        .message-content {
+            flex: 1;
+            background: white;
+            border-radius: 12px;
+            padding: 16px;
+            box-shadow: 0 2px 8px rgba(0, 0, 0, 0.08);
+            position: relative;
+        }
+
+        .message-content::before {
+            content: '';
+            position: absolute;
+            left: -8px;
+            top: 20px;
+            width: 0;
+            height: 0;
+            border-top: 8px solid transparent;
+            border-bottom: 8px solid transparent;
+            border-right: 8px solid white;
+        }
+
+        .message-header {
+            display: flex;
+            align-items: center;
+            justify-content: space-between;
+            margin-bottom: 8px;
+        }
+
+        .message-username {
+            font-weight: 600;
+            color: #2c3e50;
+            font-size: 14px;
+        }
+
+        .message-time {
+            color: #95a5a6;
+            font-size: 12px;
+        }
+
+        .message-text {
+            color: #495057;
+            line-height: 1.5;
+            word-wrap: break-word;
+        }
+
+        /* My messages */
+        .message.own {
+            flex-direction: row-reverse;
+        }
+
+        .message.own .message-avatar {
+            margin-left: 12px;
+            margin-right: 0;
+            background: linear-gradient(135deg, #3498db, #2980b9);
+        }
+
+        .message.own .message-content {
+            background: linear-gradient(135deg, #3498db, #2980b9);
+            color: white;
+        }
+
+        .message.own .message-content::before {
+            left: auto;
+            right: -8px;
+            border-left: 8px solid #3498db;
+            border-right: none;
+        }
+
+        .message.own .message-username {
+            color: rgba(255, 255, 255, 0.9);
+        }
+
+        .message.own .message-time {
+            color: rgba(255, 255, 255, 0.7);
+        }
+
+        .message.own .message-text {
+            color: white;
+        }
+
+        .message.mentioned .message-content {
+            box-shadow: 0 0 0 2px #f1c40f;
+        }
+
+        .link-preview {
+            margin-top: 6px;
+            padding: 6px 10px;
+            border-left: 3px solid #667eea;
+            font-size: 13px;
+        }
+
+        .message.pinned .message-content {
+            border-left: 3px solid #e67e22;
+        }
+
+        /* System messages */
+        .system-message {
+            text-align: center;
+            margin: 16px 0;
+            padding: 8px 16px;
+            background: rgba(52, 152, 219, 0.1);
+            border-radius: 20px;
+            color: #3498db;
+            font-size: 13px;
+            font-style: italic;
+        }
+
+        /* Input Area */
+        .input-container {
+            background: white;
+            padding: 24px 32px;
+            border-top: 1px solid #e9ecef;
+        }
+
+        .input-wrapper {
+            display: flex;
+            align-items: end;
+            gap: 16px;
+            background: #f8f9fa;
+            border-radius: 24px;
+            padding: 12px 20px;
+            border: 2px solid transparent;
+            transition: all 0.3s ease;
+        }
+
+        .input-wrapper:focus-within {
+            border-color: #3498db;
+            background: white;
+            box-shadow: 0 4px 12px rgba(52, 152, 219, 0.15);
+        }
+
+        #messageInput {
+            flex: 1;
+            border: none;
+            background: transparent;
+            font-size: 16px;
+            color: #495057;
+            resize: none;
+            outline: none;
+            max-height: 120px;
+            min-height: 20px;
+            line-height: 1.4;
+        }
+
+        #messageInput::placeholder {
+            color: #adb5bd;
+        }
+
+        .send-btn {
+            background: linear-gradient(135deg, #3498db, #2980b9);
+            border: none;
+            border-radius: 50%;
+            width: 48px;
+            height: 48px;
+            color: white;
+            cursor: pointer;
+            transition: all 0.3s ease;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            font-size: 18px;
+        }
+
+        .send-btn:hover {
+            transform: translateY(-2px);
+            box-shadow: 0 8px 20px rgba(52, 152, 219, 0.4);
+        }
+
+        .send-btn:disabled {
+            background: #bdc3c7;
+            cursor: not-allowed;
+            transform: none;
+            box-shadow: none;
+        }
+
+        /* Login Screen */
+        .login-overlay {
+            position: fixed;
+            top: 0;
+            left: 0;
+            width: 100%;
+            height: 100%;
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            z-index: 1000;
+        }
+
+        .login-card {
+            background: white;
+            border-radius: 20px;
+            padding: 48px;
+            box-shadow: 0 30px 80px rgba(0, 0, 0, 0.2);
+            text-align: center;
+            max-width: 400px;
+            width: 90%;
+            animation: loginSlide 0.5s ease-out;
+        }
+
+        @keyframes loginSlide {
+            from {
+                opacity: 0;
+                transform: translateY(50px);
+            }
+            to {
+                opacity: 1;
+                transform: translateY(0);
+            }
+        }
+
+        .login-logo {
+            color: #3498db;
+            font-size: 64px;
+            margin-bottom: 24px;
+        }
+
+        .login-title {
+            font-size: 28px;
+            font-weight: 700;
+            color: #2c3e50;
+            margin-bottom: 12px;
+        }
+
+        .login-subtitle {
+            color: #6c757d;
+            margin-bottom: 32px;
+            font-size: 16px;
+        }
+
+        .login-form {
+            display: flex;
+            flex-direction: column;
+            gap: 20px;
+        }
+
+        .login-input {
+            padding: 16px 20px;
+            border: 2px solid #e9ecef;
+            border-radius: 12px;
+            font-size: 16px;
+            transition: all 0.3s ease;
+        }
+
+        .login-input:focus {
+            outline: none;
+            border-color: #3498db;
+            box-shadow: 0 4px 12px rgba(52, 152, 219, 0.15);
+        }
+
+        .login-btn {
+            background: linear-gradient(135deg, #3498db, #2980b9);
+            color: white;
+            border: none;
+            padding: 16px;
+            border-radius: 12px;
+            font-size: 16px;
+            font-weight: 600;
+            cursor: pointer;
+            transition: all 0.3s ease;
+        }
+
+        .login-btn:hover {
+            transform: translateY(-2px);
+            box-shadow: 0 8px 25px rgba(52, 152, 219, 0.4);
+        }
+
+        /* Responsive Design */
+        @media (max-width: 768px) {
+            .chat-container {
+                margin: 0;
+                border-radius: 0;
+                height: 100vh;
+            }
+            
+            .sidebar {
+                width: 240px;
+            }
+            
+            .messages-container {
+                padding: 16px 20px;
+            }
+            
+            .input-container {
+                padding: 16px 20px;
+            }
+        }
+
+        /* Custom Scrollbar */
+        .messages-container::-webkit-scrollbar {
+            width: 6px;
+        }
+
+        .messages-container::-webkit-scrollbar-track {
+            background: #f1f1f1;
+            border-radius: 3px;
+        }
+
+        .messages-container::-webkit-scrollbar-thumb {
+            background: #c1c1c1;
+            border-radius: 3px;
+        }
+
+        .messages-container::-webkit-scrollbar-thumb:hover {
+            background: #a8a8a8;
+        }
+    </style>
+</head>
+<body>
+    <!-- Login Screen -->
+    <div id="loginOverlay" class="login-overlay">
+        <div class="login-card">
+            <div class="login-logo">
+                <i class="fas fa-comments"></i>
+            </div>
+            <h1 class="login-title">Welcome to WorkChat Pro</h1>
+            <p class="login-subtitle">Connect and collaborate with your team</p>
+            <div class="login-form">
+                <input type="text" id="usernameInput" class="login-input" placeholder="Enter your name" maxlength="20">
+                <button onclick="connect()" class="login-btn">
+                    <i class="fas fa-sign-in-alt"></i> Join Workspace
+                </button>
+            </div>
+        </div>
+    </div>
+
+    <!-- Main Chat Interface -->
+    <div id="chatContainer" class="chat-container" style="display: none;">
+        <!-- Sidebar -->
+        <div class="sidebar">
+            <div class="logo">
+                <h1><i class="fas fa-comments"></i> WorkChat Pro</h1>
+            </div>
+            
+            <div class="user-info">
+                <div class="user-avatar" id="userAvatar">U</div>
+                <div class="user-name" id="userName">User</div>
+                <div class="user-status">
+                    <div class="status-dot"></div>
+                    <span id="userStatus">Online</span>
+                </div>
+            </div>
+            
+            <div class="channels">
+                <div class="channel-header">Channels</div>
+                <div class="channel active" data-room="general" onclick="switchRoom('general')">
+                    <i class="fas fa-hashtag"></i> general
+                </div>
+                <div class="channel" data-room="random" onclick="switchRoom('random')">
+                    <i class="fas fa-hashtag"></i> random
+                </div>
+                <div class="channel" data-room="private" onclick="switchRoom('private')">
+                    <i class="fas fa-lock"></i> private
+                </div>
+            </div>
+
+            <div class="channels">
+                <div class="channel-header">Online</div>
+                <div id="onlineUsers"></div>
+            </div>
+        </div>
+
+        <!-- Main Content -->
+        <div class="main-content">
+            <div class="chat-header">
+                <div class="chat-title">
+                    <i class="fas fa-hashtag channel-icon"></i>
+                    <h2 id="roomTitle">general</h2>
+                </div>
+                <div class="chat-actions">
+                    <button class="action-btn" title="Search"><i class="fas fa-search"></i></button>
+                    <button class="action-btn" title="Call"><i class="fas fa-phone"></i></button>
+                    <button class="action-btn" title="Settings"><i class="fas fa-cog"></i></button>
+                </div>
+            </div>
+
+            <div class="messages-container" id="messages">
+                <!-- Messages will be inserted here -->
+            </div>
+
+            <div class="input-container">
+                <div class="input-wrapper">
+                    <textarea id="messageInput" placeholder="Type your message..." rows="1"></textarea>
+                    <button class="send-btn" onclick="sendMessage()" id="sendBtn">
+                        <i class="fas fa-paper-plane"></i>
+                    </button>
+                </div>
+            </div>
+        </div>
+    </div>
+
+    <script>
+        let ws = null;
+        let username = '';
+        let currentUser = '';
+        let currentRoom = 'general';
+        let switchingRoom = false;
+        let lastSeenId = '';
+        let connected = false;
+        let reconnectDelay = 0;
+
+        function connect() {
+            const input = document.getElementById('usernameInput');
+            username = input.value.trim();
+            
+            if (!username) {
+                input.focus();
+                return;
+            }
+
+            currentUser = username;
+            openSocket();
+        }
+
+        function openSocket() {
+            let url = 'ws://localhost:8080/ws?username=' + encodeURIComponent(username) +
+                '&room=' + encodeURIComponent(currentRoom);
+            const params = new URLSearchParams(window.location.search);
+            ['token', 'color', 'avatarUrl'].forEach(function(name) {
+                const value = params.get(name);
+                if (value) {
+                    url += '&' + name + '=' + encodeURIComponent(value);
+                }
+            });
+            if (lastSeenId) {
+                url += '&resume=' + encodeURIComponent(lastSeenId);
+            }
+            ws = new WebSocket(url);
+            
+            ws.onopen = function() {
+                connected = true;
+                reconnectDelay = 0;
+                document.getElementById('loginOverlay').style.display = 'none';
+                document.getElementById('chatContainer').style.display = 'flex';
+                
+                // Update user info in sidebar
+                document.getElementById('userName').textContent = username;
+                document.getElementById('userAvatar').textContent = username.charAt(0).toUpperCase();
+                
+                // Focus message input
+                document.getElementById('messageInput').focus();
+            };
+            
+            ws.onmessage = function(event) {
+                const message = JSON.parse(event.data);
+                displayMessage(message);
+            };
+            
+            ws.onclose = function(event) {
+                if (switchingRoom) {
+                    switchingRoom = false;
+                    openSocket();
+                    return;
+                }
+                if (event.code === 4404 || event.code === 4403) {
+                    // The room doesn't exist or isn't open to us; go back to the default one
+                    alert(event.reason);
+                    currentRoom = 'general';
+                    document.querySelectorAll('.channel').forEach(function(el) {
+                        el.classList.toggle('active', el.dataset.room === currentRoom);
+                    });
+                    document.getElementById('roomTitle').textContent = currentRoom;
+                    lastSeenId = '';
+                    openSocket();
+                    return;
+                }
+                if (event.code === 4409) {
+                    // Signed in somewhere else; reconnecting would take the session back
+                    alert(event.reason);
+                    connected = false;
+                }
+                if (connected) {
+                    scheduleReconnect(event.reason);
+                    return;
+                }
+                document.getElementById('loginOverlay').style.display = 'flex';
+                document.getElementById('chatContainer').style.display = 'none';
+            };
+
+            ws.onerror = function(error) {
+                if (!connected) {
+                    alert('Connection failed. Please try again.');
+                }
+            };
+        }
+
+        // The server's close reason looks like "retry=1000;resume=<id>".
+        function scheduleReconnect(reason) {
+            const hint = {};
+            (reason || '').split(';').forEach(function(part) {
+                const kv = part.split('=');
+                if (kv.length === 2) hint[kv[0]] = kv[1];
+            });
+            if (!lastSeenId && hint.resume) {
+                lastSeenId = hint.resume;
+            }
+            const base = parseInt(hint.retry, 10) || 1000;
+            reconnectDelay = Math.min(reconnectDelay ? reconnectDelay * 2 : base, 30000);
+            setTimeout(openSocket, reconnectDelay);
+        }
+
+        function switchRoom(room) {
+            if (room === currentRoom || !ws) return;
+
+            currentRoom = room;
+            document.querySelectorAll('.channel').forEach(function(el) {
+                el.classList.toggle('active', el.dataset.room === room);
+            });
+            document.getElementById('roomTitle').textContent = room;
+            document.getElementById('messages').innerHTML = '';
+            lastSeenId = '';
+
+            // The server replays the new room's history on reconnect
+            switchingRoom = true;
+            ws.close();
+        }
+
+        function sendMessage() {
+            const input = document.getElementById('messageInput');
+            const content = input.value.trim();
+            
+            if (!content || !ws || ws.readyState !== WebSocket.OPEN) return;
+
+            // "/msg name text" sends a direct message
+            const dm = content.match(/^\/msg\s+(\S+)\s+([\s\S]+)$/);
+            // "/mute name" and "/unmute name" hide or restore someone's messages
+            const mute = content.match(/^\/(mute|unmute)\s+(\S+)$/);
+            if (dm) {
+                ws.send(JSON.stringify({ type: 'dm', to: dm[1], content: dm[2], platform: 'web' }));
+            } else if (mute) {
+                ws.send(JSON.stringify({ type: mute[1], target: mute[2] }));
+            } else {
+                ws.send(JSON.stringify({ content: content, platform: 'web' }));
+            }
+            input.value = '';
+            adjustTextareaHeight(input);
+        }
+
+        function updateOnlineUsers(names) {
+            const list = document.getElementById('onlineUsers');
+            list.innerHTML = '';
+            names.forEach(function(name) {
+                const item = document.createElement('div');
+                item.className = 'online-user';
+
+                const dot = document.createElement('div');
+                dot.className = 'status-dot';
+
+                const label = document.createElement('span');
+                label.textContent = name;
+
+                item.appendChild(dot);
+                item.appendChild(label);
+                list.appendChild(item);
+            });
+        }
+
+        function displayMessage(message) {
+            if (message.type === 'presence') {
+                updateOnlineUsers(message.online || []);
+                return;
+            }
+
+            if (message.type === 'latency') {
+                document.getElementById('userStatus').textContent = 'Online · ' + (message.rttMs || 0) + ' ms';
+                return;
+            }
+
+            if (message.type === 'connected') {
+                // The server may have changed our name or room
+                currentRoom = message.room;
+                document.querySelectorAll('.channel').forEach(function(el) {
+                    el.classList.toggle('active', el.dataset.room === currentRoom);
+                });
+                document.getElementById('roomTitle').textContent = currentRoom;
+            }
+
+            if (message.type === 'whoami' || message.type === 'connected') {
+                currentUser = message.username;
+                document.getElementById('userName').textContent = message.username;
+                document.getElementById('userAvatar').textContent = message.username.charAt(0).toUpperCase();
+                return;
+            }
+
+            const messagesDiv = document.getElementById('messages');
+
+            if (message.type === 'chat' && message.id) {
+                lastSeenId = message.id;
+                // A resumed connection may replay what we already show
+                if (messagesDiv.querySelector('[data-id="' + message.id + '"]')) {
+                    return;
+                }
+            }
+
+            if (message.type === 'unfurl') {
+                const target = messagesDiv.querySelector('[data-id="' + message.id + '"] .message-content');
+                if (target && message.preview && !target.querySelector('.link-preview')) {
+                    const card = document.createElement('div');
+                    card.className = 'link-preview';
+                    const title = document.createElement('a');
+                    title.href = message.preview.url;
+                    title.target = '_blank';
+                    title.rel = 'noopener noreferrer';
+                    title.textContent = message.preview.title || message.preview.url;
+                    card.appendChild(title);
+                    if (message.preview.description) {
+                        const desc = document.createElement('div');
+                        desc.textContent = message.preview.description;
+                        card.appendChild(desc);
+                    }
+                    target.appendChild(card);
+                }
+                return;
+            }
+
+            if (message.type === 'delete') {
+                const expired = messagesDiv.querySelector('[data-id="' + message.id + '"]');
+                if (expired) {
+                    expired.remove();
+                }
+                return;
+            }
+
+            if (message.type === 'pin' || message.type === 'unpin') {
+                const pinned = messagesDiv.querySelector('[data-id="' + message.id + '"]');
+                if (pinned) {
+                    pinned.classList.toggle('pinned', message.type === 'pin');
+                }
+                const noticeDiv = document.createElement('div');
+                noticeDiv.className = 'system-message';
+                noticeDiv.textContent = message.username + ' ' + message.type + 'ned ' + message.target + ': ' + message.content;
+                messagesDiv.appendChild(noticeDiv);
+                messagesDiv.scrollTop = messagesDiv.scrollHeight;
+                return;
+            }
+
+            if (message.type === 'mention') {
+                // A mention in this room is already on screen as the chat
+                // message itself.
+                if (message.room === currentRoom) return;
+                const noticeDiv = document.createElement('div');
+                noticeDiv.className = 'system-message';
+                noticeDiv.textContent = message.username + ' mentioned you in #' + message.room + ': ' + message.content;
+                messagesDiv.appendChild(noticeDiv);
+                messagesDiv.scrollTop = messagesDiv.scrollHeight;
+                return;
+            }
+            
+            // Check if it's a system message
+            if (message.username === 'System') {
+                const systemDiv = document.createElement('div');
+                systemDiv.className = 'system-message';
+                systemDiv.textContent = message.content;
+                messagesDiv.appendChild(systemDiv);
+            } else {
+                const messageDiv = document.createElement('div');
+                messageDiv.className = message.username === currentUser ? 'message own' : 'message';
+                if (message.id) {
+                    messageDiv.dataset.id = message.id;
+                }
+                if ((message.mentions || []).includes(currentUser)) {
+                    messageDiv.classList.add('mentioned');
+                }
+                
+                const avatar = document.createElement('div');
+                avatar.className = 'message-avatar';
+                avatar.textContent = message.username.charAt(0).toUpperCase();
+                if (message.color) {
+                    avatar.style.background = message.color;
+                }
+                if (message.avatarUrl) {
+                    const img = document.createElement('img');
+                    img.src = message.avatarUrl;
+                    img.alt = message.username;
+                    img.style.width = '100%';
+                    img.style.height = '100%';
+                    img.style.borderRadius = '50%';
+                    avatar.textContent = '';
+                    avatar.appendChild(img);
+                }
+                
+                const content = document.createElement('div');
+                content.className = 'message-content';
+                
+                const header = document.createElement('div');
+                header.className = 'message-header';
+                
+                const usernameSpan = document.createElement('span');
+                usernameSpan.className = 'message-username';
+                usernameSpan.textContent = message.username;
+                if (message.verified) {
+                    usernameSpan.textContent += ' \u2714';
+                    usernameSpan.title = 'Verified bot';
+                }
+                if (message.type === 'dm') {
+                    usernameSpan.textContent += ' \u2192 ' + message.to + ' (private)';
+                }
+                
+                const timeSpan = document.createElement('span');
+                timeSpan.className = 'message-time';
+                timeSpan.textContent = new Date(message.timestamp).toLocaleTimeString();
+                
+                const textDiv = document.createElement('div');
+                textDiv.className = 'message-text';
+                textDiv.textContent = message.content;
+                
+                header.appendChild(usernameSpan);
+                header.appendChild(timeSpan);
+                content.appendChild(header);
+                content.appendChild(textDiv);
+                
+                messageDiv.appendChild(avatar);
+                messageDiv.appendChild(content);
+                messagesDiv.appendChild(messageDiv);
+            }
+            
+            messagesDiv.scrollTop = messagesDiv.scrollHeight;
+        }
+
+        function adjustTextareaHeight(textarea) {
+            textarea.style.height = 'auto';
+            textarea.style.height = Math.min(textarea.scrollHeight, 120) + 'px';
+        }
+
+        // Event listeners
+        document.addEventListener('DOMContentLoaded', function() {
+            const messageInput = document.getElementById('messageInput');
+            const usernameInput = document.getElementById('usernameInput');
+            
+            messageInput.addEventListener('input', function() {
+                adjustTextareaHeight(this);
+            });
+
+            messageInput.addEventListener('keydown', function(e) {
+                if (e.key === 'Enter' && !e.shiftKey) {
+                    e.preventDefault();
+                    sendMessage();
+                }
+            });
+
+            usernameInput.addEventListener('keydown', function(e) {
+                if (e.key === 'Enter') {
+                    e.preventDefault();
+                    connect();
+                }
+            });
+
+            // Auto-focus username input
+            usernameInput.focus();
+        });
+    </script>
+</body>
+</html>`
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(html))
+}
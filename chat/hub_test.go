@@ -0,0 +1,289 @@
+package chat
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestHubConcurrentClients joins, chats and leaves from many connections at
+// once. It is meant for go test -race.
+func TestHubConcurrentClients(t *testing.T) {
+	tests := []struct {
+		clients, messages int
+	}{
+		{clients: 2, messages: 20},
+		{clients: 20, messages: 5},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%dx%d", tt.clients, tt.messages), func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.RoomRate = 0
+			ts := startServer(t, cfg)
+
+			var wg sync.WaitGroup
+			for i := range tt.clients {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					conn, _, err := ts.tryDial(user(fmt.Sprintf("user-%d", i)), nil)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					defer conn.Close()
+					for j := range tt.messages {
+						if err := conn.WriteJSON(Message{Content: fmt.Sprintf("%d from %d", j, i)}); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			// Everyone has gone; a new client must still get in and be
+			// alone in the room once the hub catches up.
+			last := ts.dial(t, user("last"))
+			last.await("an empty room", isPresence("last"))
+			var clients int
+			ts.srv.hub.do(func() { clients = len(ts.srv.hub.clients) })
+			if clients != 1 {
+				t.Errorf("hub has %d clients, want 1", clients)
+			}
+		})
+	}
+}
+
+// TestDropThenUnregister drops clients for a full send buffer and has them
+// unregister in either order; neither may close send twice.
+func TestDropThenUnregister(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []string
+	}{
+		// The buffer holds one message, so the second send drops.
+		{"drop then unregister", []string{"send", "send", "leave"}},
+		{"unregister then send", []string{"leave", "send"}},
+		{"drop twice then unregister", []string{"send", "send", "send", "leave"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(DefaultConfig())
+			go h.Run()
+			defer h.Stop()
+
+			c := &Client{id: newID(), hub: h, username: "alice", room: defaultRoom, send: make(chan Message, 1)}
+			h.do(func() {
+				h.clients[c] = true
+				h.addUserConn(c)
+				h.room(defaultRoom).clients[c] = true
+			})
+			for _, step := range tt.steps {
+				switch step {
+				case "send":
+					h.do(func() { h.sendTo(c, Message{Type: typeChat}) })
+				case "leave":
+					c.leave()
+				}
+			}
+			var registered bool
+			h.do(func() { registered = h.clients[c] })
+			if registered {
+				t.Error("client is still registered")
+			}
+		})
+	}
+}
+
+// TestConnectToStoppedHub connects after the hub has stopped but while HTTP
+// is still being served, as happens during shutdown.
+func TestConnectToStoppedHub(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      url.Values
+		wantStatus int
+		wantClose  int
+	}{
+		{"named", user("alice"), 0, websocket.CloseGoingAway},
+		{"anonymous", url.Values{}, http.StatusServiceUnavailable, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := startServer(t, DefaultConfig())
+			ts.srv.hub.Stop()
+
+			conn, resp, err := ts.tryDial(tt.query, nil)
+			if tt.wantStatus != 0 {
+				if err == nil || resp == nil || resp.StatusCode != tt.wantStatus {
+					t.Fatalf("dial = %v, %v; want status %d", resp, err, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(testTimeout))
+			_, _, err = conn.ReadMessage()
+			if !websocket.IsCloseError(err, tt.wantClose) {
+				t.Errorf("read = %v, want close code %d", err, tt.wantClose)
+			}
+		})
+	}
+}
+
+func TestDoubleRegisterAndUnregister(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []string
+		want  int
+	}{
+		{"register twice", []string{"register"}, 1},
+		{"unregister twice", []string{"unregister", "unregister"}, 0},
+		{"register after unregister", []string{"unregister", "register"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := startServer(t, DefaultConfig())
+			h := ts.srv.hub
+			ts.dial(t, user("alice")).await("alice's presence", isPresence("alice"))
+
+			// Repeat what serveWS and leave send for the connected client.
+			var c *Client
+			h.do(func() { c = h.users["alice"][0] })
+			for _, step := range tt.steps {
+				switch step {
+				case "register":
+					h.register <- c
+				case "unregister":
+					h.unregister <- c
+				}
+			}
+			var clients, conns int
+			h.do(func() { clients, conns = len(h.clients), len(h.users["alice"]) })
+			if clients != tt.want || conns != tt.want {
+				t.Errorf("hub has %d clients and %d connections for alice, want %d", clients, conns, tt.want)
+			}
+		})
+	}
+}
+
+// TestServerOnlyFieldsAreDropped sends messages carrying fields only the
+// server may set and checks none of them reach the recipient.
+func TestServerOnlyFieldsAreDropped(t *testing.T) {
+	tests := []struct {
+		name   string
+		send   string
+		forged func(Message) bool
+	}{
+		{"preview", `{"content":"hi","preview":{"url":"https://evil.example"}}`, func(m Message) bool { return m.Preview != nil }},
+		{"history", `{"content":"hi","history":[{"content":"fake"}]}`, func(m Message) bool { return m.History != nil }},
+		{"online", `{"content":"hi","online":["nobody"]}`, func(m Message) bool { return m.Online != nil }},
+		{"capabilities", `{"content":"hi","capabilities":{"resume":true}}`, func(m Message) bool { return m.Capabilities != nil }},
+		{"rtt", `{"content":"hi","rttMs":5}`, func(m Message) bool { return m.RTTMillis != 0 }},
+		{"verified", `{"content":"hi","verified":true}`, func(m Message) bool { return m.Verified }},
+		{"priority", `{"content":"hi","priority":true}`, func(m Message) bool { return m.Priority }},
+		{"username", `{"content":"hi","username":"admin"}`, func(m Message) bool { return m.Username != "alice" }},
+		{"room", `{"content":"hi","room":"secret"}`, func(m Message) bool { return m.Room != defaultRoom }},
+		{"dm mentions", `{"type":"dm","to":"bob","content":"hi","mentions":["carol"]}`, func(m Message) bool { return m.Mentions != nil }},
+		{"dm room", `{"type":"dm","to":"bob","content":"hi","room":"general"}`, func(m Message) bool { return m.Room != "" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := startServer(t, DefaultConfig())
+			alice := ts.dial(t, user("alice"))
+			alice.await("alice's presence", isPresence("alice"))
+			bob := ts.dial(t, user("bob"))
+			alice.await("bob joining", isPresence("alice", "bob"))
+
+			if err := alice.conn.WriteMessage(websocket.TextMessage, []byte(tt.send)); err != nil {
+				t.Fatal(err)
+			}
+			got := bob.await("alice's message", func(m Message) bool {
+				return (m.Type == typeChat || m.Type == typeDirect) && m.Content == "hi"
+			})
+			if tt.forged(got) {
+				t.Errorf("bob got a forged %s: %+v", tt.name, got)
+			}
+		})
+	}
+}
+
+// TestConcurrentWriteClosesConnection makes writePump's next write find
+// another write in progress. That client is hung up on; the server and
+// everyone else carry on.
+func TestConcurrentWriteClosesConnection(t *testing.T) {
+	tests := []struct {
+		name    string
+		trigger func(h *Hub, c *Client)
+	}{
+		{"data frame", func(h *Hub, c *Client) { c.command(Message{Type: typeWhoami}) }},
+		{"close frame", func(h *Hub, c *Client) { h.do(func() { h.removeClient(c) }) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := startServer(t, DefaultConfig())
+			h := ts.srv.hub
+			alice := ts.dial(t, user("alice"))
+			alice.await("alice's presence", isPresence("alice"))
+			bob := ts.dial(t, user("bob"))
+			bob.await("bob's presence", isPresence("alice", "bob"))
+
+			var c *Client
+			h.do(func() { c = h.users["alice"][0] })
+			c.writing.Store(true)
+			tt.trigger(h, c)
+
+			alice.conn.SetReadDeadline(time.Now().Add(testTimeout))
+			var err error
+			for err == nil {
+				_, _, err = alice.conn.ReadMessage()
+			}
+			if isTimeout(err) {
+				t.Fatal("alice's connection wasn't closed")
+			}
+			bob.await("alice leaving", isPresence("bob"))
+			carol := ts.dial(t, user("carol"))
+			carol.send(Message{Content: "still up"})
+			bob.await("carol's message", isChat("still up"))
+		})
+	}
+}
+
+func TestMuteLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		already int
+		target  string
+		typ     string
+		want    bool // whether target ends up muted
+	}{
+		{"under the limit", maxMutes - 1, "bob", typeMute, true},
+		{"at the limit", maxMutes, "bob", typeMute, false},
+		{"muting someone already muted", maxMutes, "user-0", typeMute, true},
+		{"unmuting at the limit", maxMutes, "user-0", typeUnmute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(DefaultConfig())
+			c := &Client{hub: h, username: "alice", room: defaultRoom, muted: make(map[string]bool), send: make(chan Message, 2), priority: make(chan Message, 2)}
+			h.clients[c] = true
+			for i := range tt.already {
+				c.muted[fmt.Sprintf("user-%d", i)] = true
+			}
+			h.handleCommand(command{client: c, msg: Message{Type: tt.typ, Target: tt.target}})
+			if c.muted[tt.target] != tt.want {
+				t.Errorf("%s muted = %v, want %v", tt.target, c.muted[tt.target], tt.want)
+			}
+			if len(c.muted) > maxMutes {
+				t.Errorf("%d users muted, limit is %d", len(c.muted), maxMutes)
+			}
+		})
+	}
+}
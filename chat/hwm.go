@@ -0,0 +1,40 @@
+package chat
+
+import "log"
+
+func (h *Hub) debugf(format string, args ...any) {
+	if h.cfg.Debug {
+		log.Printf(format, args...)
+	}
+}
+
+// sendHWMBuckets groups send buffer high-water marks by how close to full
+// they came, as a fraction of the buffer size.
+var sendHWMBuckets = []struct {
+	name string
+	max  float64
+}{
+	{"0-25%", 0.25},
+	{"25-50%", 0.5},
+	{"50-75%", 0.75},
+	{"75-90%", 0.9},
+	{"90-100%", 1.01},
+}
+
+func sendHWMBucket(n, capacity int) string {
+	frac := float64(n) / float64(capacity)
+	for _, b := range sendHWMBuckets {
+		if frac < b.max {
+			return b.name
+		}
+	}
+	return sendHWMBuckets[len(sendHWMBuckets)-1].name
+}
+
+// noteSendDepth records how full a client's send buffer has been. It must
+// only be called from Run().
+func (c *Client) noteSendDepth() {
+	if n := len(c.send); n > c.sendHWM {
+		c.sendHWM = n
+	}
+}
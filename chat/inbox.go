@@ -0,0 +1,305 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"time"
+)
+
+// inboxStore is implemented by stores that can hold direct messages for
+// offline users until they next connect.
+type inboxStore interface {
+	AddInbox(user string, m Message) error
+	// TakeInbox returns and forgets a user's undelivered messages, oldest
+	// first, leaving out any that have expired.
+	TakeInbox(user string) ([]Message, error)
+}
+
+// inboxSweeper is implemented by stores that have to drop expired inbox
+// messages themselves rather than relying on the backend to expire them.
+type inboxSweeper interface {
+	sweepInboxes(now time.Time)
+}
+
+var (
+	errNoInbox = errors.New("store has no inbox")
+	// errUnknownRecipient and errInboxesFull mean the message isn't kept;
+	// the sender is told the recipient is offline as usual.
+	errUnknownRecipient = errors.New("recipient hasn't connected since the store started")
+	errInboxesFull      = errors.New("too many offline users have undelivered messages")
+)
+
+// inboxLimits bound each user's inbox: at most size messages, none older
+// than ttl. The in-memory store also keeps at most max inboxes in all.
+type inboxLimits struct {
+	size int
+	ttl  time.Duration
+	max  int
+}
+
+func (cfg Config) inboxLimits() inboxLimits {
+	return inboxLimits{size: cfg.InboxSize, ttl: cfg.InboxTTL, max: cfg.MaxInboxes}
+}
+
+// inboxSweepInterval is how often the in-memory store's inboxes are
+// checked for expired messages.
+const inboxSweepInterval = time.Minute
+
+// unexpired drops inbox messages older than the TTL.
+func (l inboxLimits) unexpired(messages []Message) []Message {
+	cutoff := time.Now().Add(-l.ttl)
+	return slices.DeleteFunc(messages, func(m Message) bool { return m.Timestamp.Before(cutoff) })
+}
+
+func (s *memoryStore) AddInbox(user string, m Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inbox.size <= 0 {
+		return errNoInbox
+	}
+	// Only users who have connected get an inbox, so direct messages to
+	// made-up names can't fill memory.
+	if _, ok := s.known[user]; !ok {
+		return errUnknownRecipient
+	}
+	box, ok := s.inboxes[user]
+	if !ok && s.inbox.max > 0 && len(s.inboxes) >= s.inbox.max {
+		return errInboxesFull
+	}
+	box = s.inbox.unexpired(box)
+	if len(box) >= s.inbox.size {
+		box = box[len(box)-s.inbox.size+1:]
+	}
+	s.inboxes[user] = append(box, m)
+	return nil
+}
+
+func (s *memoryStore) TakeInbox(user string) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inbox.size > 0 {
+		s.known[user] = time.Now()
+	}
+	box := s.inboxes[user]
+	delete(s.inboxes, user)
+	return s.inbox.unexpired(box), nil
+}
+
+// sweepInboxes drops expired messages and the inboxes they leave empty,
+// and forgets users who haven't connected for the inbox TTL: anything
+// queued for them would expire before it could be delivered.
+func (s *memoryStore) sweepInboxes(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for user, box := range s.inboxes {
+		if box = s.inbox.unexpired(box); len(box) == 0 {
+			delete(s.inboxes, user)
+		} else {
+			s.inboxes[user] = box
+		}
+	}
+	for user, seen := range s.known {
+		if now.Sub(seen) > s.inbox.ttl {
+			delete(s.known, user)
+		}
+	}
+}
+
+func redisInboxKey(user string) string {
+	return "chat:inbox:" + user
+}
+
+func (s *redisStore) AddInbox(user string, m Message) error {
+	if s.inbox.size <= 0 {
+		return errNoInbox
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	key := redisInboxKey(user)
+	pipe := s.client.TxPipeline()
+	pipe.RPush(ctx, key, data)
+	pipe.LTrim(ctx, key, int64(-s.inbox.size), -1)
+	// The newest message keeps the whole inbox alive; older ones are
+	// filtered out on the way out.
+	pipe.Expire(ctx, key, s.inbox.ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) TakeInbox(user string) ([]Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	key := redisInboxKey(user)
+	pipe := s.client.TxPipeline()
+	vals := pipe.LRange(ctx, key, 0, -1)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	var messages []Message
+	for _, v := range vals.Val() {
+		var m Message
+		if err := json.Unmarshal([]byte(v), &m); err != nil {
+			log.Printf("Skipping unreadable inbox entry for %s: %v", user, err)
+			continue
+		}
+		messages = append(messages, m)
+	}
+	return s.inbox.unexpired(messages), nil
+}
+
+// The wrapping stores pass inbox calls through. Direct messages aren't
+// tied to a room, so encryptedStore seals them with the -db-key alone,
+// under "@user", which no room can be called.
+
+func (s *encryptedStore) AddInbox(user string, m Message) error {
+	i, ok := s.MessageStore.(inboxStore)
+	if !ok {
+		return errNoInbox
+	}
+	if s.dbKey != nil {
+		content, err := sealContent(s.dbKey, "@"+user, m)
+		if err != nil {
+			return err
+		}
+		m.Content = content
+		m.Sealed = true
+	}
+	return i.AddInbox(user, m)
+}
+
+func (s *encryptedStore) TakeInbox(user string) ([]Message, error) {
+	i, ok := s.MessageStore.(inboxStore)
+	if !ok {
+		return nil, nil
+	}
+	messages, err := i.TakeInbox(user)
+	if err != nil {
+		return nil, err
+	}
+	out := messages[:0]
+	for _, m := range messages {
+		content, err := openContent(s.dbKey, "@"+user, m)
+		if err != nil {
+			log.Printf("Skipping direct message %s to %s: %v", m.ID, user, err)
+			continue
+		}
+		m.Content = content
+		m.Sealed = false
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+func (s *encryptedStore) sweepInboxes(now time.Time) {
+	if i, ok := s.MessageStore.(inboxSweeper); ok {
+		i.sweepInboxes(now)
+	}
+}
+
+func (s *resilientStore) AddInbox(user string, m Message) error {
+	i, ok := s.MessageStore.(inboxStore)
+	if !ok {
+		return errNoInbox
+	}
+	if s.down() {
+		return errStorageDown
+	}
+	if err := i.AddInbox(user, m); err != nil {
+		return s.failed(err)
+	}
+	s.ok()
+	return nil
+}
+
+func (s *resilientStore) TakeInbox(user string) ([]Message, error) {
+	i, ok := s.MessageStore.(inboxStore)
+	if !ok || s.down() {
+		return nil, nil
+	}
+	messages, err := i.TakeInbox(user)
+	if err != nil {
+		return nil, s.failed(err)
+	}
+	s.ok()
+	return messages, nil
+}
+
+// storeInbox keeps a direct message for an offline recipient, then calls
+// kept on Run() with whether it was. It must only be called from Run().
+func (h *Hub) storeInbox(message Message, kept func(bool)) {
+	message.received = time.Time{}
+	message.ClientMsgID = ""
+	h.storeThen(func(s MessageStore) error {
+		i, ok := s.(inboxStore)
+		if !ok {
+			return errNoInbox
+		}
+		return i.AddInbox(message.To, message)
+	}, func(err error) {
+		switch {
+		case err == nil:
+		case errors.Is(err, errNoInbox), errors.Is(err, errUnknownRecipient):
+		case errors.Is(err, errInboxesFull), errors.Is(err, errStorageBusy):
+			h.debugf("Not keeping direct message %s for %s: %v", message.ID, message.To, err)
+		default:
+			log.Printf("Failed to keep direct message %s for %s: %v", message.ID, message.To, err)
+		}
+		kept(err == nil)
+	})
+}
+
+// restoreInbox puts back direct messages taken for a client that left
+// before it got them. It must only be called from Run().
+func (h *Hub) restoreInbox(user string, messages []Message) {
+	if len(messages) == 0 {
+		return
+	}
+	h.storeLater("direct messages for "+user, func(s MessageStore) {
+		i, ok := s.(inboxStore)
+		if !ok {
+			return
+		}
+		for _, m := range messages {
+			if err := i.AddInbox(user, m); err != nil {
+				log.Printf("Lost direct message %s for %s: %v", m.ID, user, err)
+			}
+		}
+	})
+}
+
+// sweepInboxes has the store drop expired inbox messages, at most once
+// every inboxSweepInterval. It must only be called from Run().
+func (h *Hub) sweepInboxes(now time.Time) {
+	if now.Sub(h.inboxesSwept) < inboxSweepInterval {
+		return
+	}
+	h.inboxesSwept = now
+	h.storeLater("inbox sweep", func(s MessageStore) {
+		if i, ok := s.(inboxSweeper); ok {
+			i.sweepInboxes(now)
+		}
+	})
+}
+
+// takeInbox takes the direct messages that arrived while user was offline.
+// It runs on the storage goroutine.
+func takeInbox(s MessageStore, user string) []Message {
+	i, ok := s.(inboxStore)
+	if !ok {
+		return nil
+	}
+	messages, err := i.TakeInbox(user)
+	if err != nil {
+		log.Printf("Inbox for %s unavailable: %v", user, err)
+	}
+	return messages
+}
@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreAddInbox(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit inboxLimits
+		known []string
+		full  int // inboxes already held for other users
+		to    string
+		want  error
+	}{
+		{"known user", inboxLimits{size: 5, ttl: time.Hour, max: 10}, []string{"alice"}, 0, "alice", nil},
+		{"unknown user", inboxLimits{size: 5, ttl: time.Hour, max: 10}, []string{"alice"}, 0, "mallory", errUnknownRecipient},
+		{"inbox disabled", inboxLimits{ttl: time.Hour, max: 10}, []string{"alice"}, 0, "alice", errNoInbox},
+		{"all inboxes taken", inboxLimits{size: 5, ttl: time.Hour, max: 3}, []string{"alice"}, 3, "alice", errInboxesFull},
+		{"no cap", inboxLimits{size: 5, ttl: time.Hour}, []string{"alice"}, 100, "alice", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newMemoryStore(historySizes{}, tt.limit)
+			for _, user := range tt.known {
+				s.TakeInbox(user)
+			}
+			for i := range tt.full {
+				user := fmt.Sprintf("user-%d", i)
+				s.known[user] = time.Now()
+				s.inboxes[user] = []Message{{Timestamp: time.Now()}}
+			}
+			err := s.AddInbox(tt.to, Message{ID: "m1", Timestamp: time.Now()})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("AddInbox = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil {
+				if got, _ := s.TakeInbox(tt.to); len(got) != 1 {
+					t.Errorf("inbox holds %d messages, want 1", len(got))
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStoreAddInboxExistingInboxIgnoresCap(t *testing.T) {
+	s := newMemoryStore(historySizes{}, inboxLimits{size: 5, ttl: time.Hour, max: 1})
+	s.TakeInbox("alice")
+	for i := range 3 {
+		if err := s.AddInbox("alice", Message{ID: fmt.Sprint(i), Timestamp: time.Now()}); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+}
+
+func TestMemoryStoreSweepInboxes(t *testing.T) {
+	now := time.Now()
+	s := newMemoryStore(historySizes{}, inboxLimits{size: 5, ttl: time.Hour, max: 10})
+	s.known["alice"] = now
+	s.known["bob"] = now.Add(-2 * time.Hour)
+	s.inboxes["alice"] = []Message{{ID: "old", Timestamp: now.Add(-2 * time.Hour)}, {ID: "new", Timestamp: now}}
+	s.inboxes["carol"] = []Message{{ID: "old", Timestamp: now.Add(-2 * time.Hour)}}
+
+	s.sweepInboxes(now)
+
+	if box := s.inboxes["alice"]; len(box) != 1 || box[0].ID != "new" {
+		t.Errorf("alice's inbox = %v, want only the new message", box)
+	}
+	if _, ok := s.inboxes["carol"]; ok {
+		t.Error("carol's expired inbox wasn't dropped")
+	}
+	if _, ok := s.known["bob"]; ok {
+		t.Error("bob is still known after the inbox TTL")
+	}
+	if _, ok := s.known["alice"]; !ok {
+		t.Error("alice was forgotten")
+	}
+}
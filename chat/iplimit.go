@@ -0,0 +1,77 @@
+package chat
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// trustedProxy reports whether addr is in one of the proxies' networks.
+func trustedProxy(proxies []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range proxies {
+		if p.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address a request came from. Behind trusted proxies it
+// walks X-Forwarded-For from the right, skipping proxy hops, so a client
+// can't pick its own address by sending the header itself.
+func clientIP(proxies []netip.Prefix, r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !trustedProxy(proxies, addr) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop
+		if !trustedProxy(proxies, hop) {
+			break
+		}
+	}
+	return addr.Unmap().String()
+}
+
+// connLimiter counts open connections per IP. It is used from HTTP handlers
+// before a client reaches the hub, so unlike hub state it has a lock.
+type connLimiter struct {
+	mu    sync.Mutex
+	max   int
+	count map[string]int
+}
+
+func newConnLimiter(max int) *connLimiter {
+	return &connLimiter{max: max, count: make(map[string]int)}
+}
+
+// acquire reserves a connection slot for ip, reporting false if it is at
+// its cap.
+func (l *connLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.count[ip] >= l.max {
+		return false
+	}
+	l.count[ip]++
+	return true
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count[ip]--; l.count[ip] <= 0 {
+		delete(l.count, ip)
+	}
+}
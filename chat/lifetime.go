@@ -0,0 +1,32 @@
+package chat
+
+import (
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+// retireAt picks when a client connecting at connectedAt has to reconnect.
+// Up to a tenth of the lifetime is taken off at random, so clients that
+// connected together, say after a restart, don't all reconnect together.
+func retireAt(connectedAt time.Time, lifetime time.Duration) time.Time {
+	if lifetime <= 0 {
+		return time.Time{}
+	}
+	jitter := time.Duration(rand.Int64N(int64(lifetime/10) + 1))
+	return connectedAt.Add(lifetime - jitter)
+}
+
+// retireOldClients hangs up on clients past their lifetime. writePump sends
+// whatever is queued first, then a close frame asking for a reconnect with
+// the usual resume hint. It must only be called from Run().
+func (h *Hub) retireOldClients(now time.Time) {
+	for client := range h.clients {
+		if client.retireAt.IsZero() || now.Before(client.retireAt) {
+			continue
+		}
+		log.Printf("Closing %s (%s): connection lifetime reached after %v", client.username, client.id, now.Sub(client.connectedAt).Round(time.Second))
+		client.closeErr = errReconnectRequired
+		h.removeClient(client)
+	}
+}
@@ -0,0 +1,179 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// catalogs translate system message formats, keyed by the English format
+// string, for each supported locale. English needs no catalog.
+var catalogs = map[string]map[string]string{
+	"es": {
+		"#%s doesn't exist, so you've joined #%s": "#%s no existe, así que te has unido a #%s",
+		"Muted %s":      "Has silenciado a %s",
+		"Unmuted %s":    "Has dejado de silenciar a %s",
+		"%s is offline": "%s no está conectado",
+		"%s is offline and will get your message when they next connect":                         "%s no está conectado y recibirá tu mensaje la próxima vez que se conecte",
+		"the server has no room for #%s right now, try an existing room":                         "el servidor no tiene sitio para #%s ahora mismo, prueba una sala existente",
+		"This server is draining for maintenance. Please reconnect to continue chatting.":        "Este servidor se está vaciando por mantenimiento. Vuelve a conectarte para seguir chateando.",
+		"spectators can't send messages":                                                         "los espectadores no pueden enviar mensajes",
+		"spectators can't pin messages":                                                          "los espectadores no pueden fijar mensajes",
+		"only admins, the room's owner and the users the room is restricted to can pin messages": "solo los administradores, el propietario de la sala y los usuarios a los que está restringida pueden fijar mensajes",
+		"that message isn't in this room's history":                                              "ese mensaje no está en el historial de esta sala",
+		"that message isn't pinned":                                                              "ese mensaje no está fijado",
+		"#%s already has %d pinned messages; unpin one first":                                    "#%s ya tiene %d mensajes fijados; desfija uno primero",
+		"you've already muted %d people; unmute someone first":                                   "ya has silenciado a %d personas; deja de silenciar a alguien primero",
+		"slow mode is on in #%s: wait %ds before posting again":                                  "el modo lento está activo en #%s: espera %ds antes de volver a escribir",
+		"#%s is busy right now, try again in a moment":                                           "#%s está muy concurrida ahora mismo, inténtalo de nuevo en un momento",
+		"too many ephemeral messages pending, try again later":                                   "demasiados mensajes efímeros pendientes, inténtalo más tarde",
+		"#%s is archived and read-only":                                                          "#%s está archivada y es de solo lectura",
+		"#%s has been archived: you can still read it, but not post":                             "#%s se ha archivado: todavía puedes leerla, pero no escribir",
+		"#%s is open for messages again":                                                         "#%s vuelve a admitir mensajes",
+		"history is unavailable right now, try again later":                                      "el historial no está disponible ahora mismo, inténtalo más tarde",
+		"#%s only takes messages with a link in them":                                            "#%s solo admite mensajes con un enlace",
+		"messages in #%s must match %s":                                                          "los mensajes en #%s deben coincidir con %s",
+		"message metadata is %d bytes, the limit is %d":                                          "los metadatos del mensaje ocupan %d bytes, el límite es %d",
+		"the server is busy right now, try again in a moment":                                    "el servidor está muy ocupado ahora mismo, inténtalo de nuevo en un momento",
+		"you've connected from somewhere else, so this session has been closed":                  "te has conectado desde otro sitio, así que esta sesión se ha cerrado",
+	},
+	"fr": {
+		"#%s doesn't exist, so you've joined #%s": "#%s n'existe pas, vous avez donc rejoint #%s",
+		"Muted %s":      "%s est masqué",
+		"Unmuted %s":    "%s n'est plus masqué",
+		"%s is offline": "%s est hors ligne",
+		"%s is offline and will get your message when they next connect":                         "%s est hors ligne et recevra votre message à sa prochaine connexion",
+		"the server has no room for #%s right now, try an existing room":                         "le serveur ne peut pas accueillir #%s pour l'instant, essayez un salon existant",
+		"This server is draining for maintenance. Please reconnect to continue chatting.":        "Ce serveur est en cours de vidage pour maintenance. Reconnectez-vous pour continuer à discuter.",
+		"spectators can't send messages":                                                         "les spectateurs ne peuvent pas envoyer de messages",
+		"spectators can't pin messages":                                                          "les spectateurs ne peuvent pas épingler de messages",
+		"only admins, the room's owner and the users the room is restricted to can pin messages": "seuls les administrateurs, le propriétaire du salon et les utilisateurs auxquels il est réservé peuvent épingler des messages",
+		"that message isn't in this room's history":                                              "ce message n'est pas dans l'historique de ce salon",
+		"that message isn't pinned":                                                              "ce message n'est pas épinglé",
+		"#%s already has %d pinned messages; unpin one first":                                    "#%s a déjà %d messages épinglés ; désépinglez-en un d'abord",
+		"you've already muted %d people; unmute someone first":                                   "vous avez déjà masqué %d personnes ; réaffichez-en une d'abord",
+		"slow mode is on in #%s: wait %ds before posting again":                                  "le mode lent est actif dans #%s : attendez %ds avant de publier à nouveau",
+		"#%s is busy right now, try again in a moment":                                           "#%s est très active en ce moment, réessayez dans un instant",
+		"too many ephemeral messages pending, try again later":                                   "trop de messages éphémères en attente, réessayez plus tard",
+		"#%s is archived and read-only":                                                          "#%s est archivé et en lecture seule",
+		"#%s has been archived: you can still read it, but not post":                             "#%s a été archivé : vous pouvez encore le lire, mais pas y publier",
+		"#%s is open for messages again":                                                         "#%s accepte de nouveau les messages",
+		"history is unavailable right now, try again later":                                      "l'historique est indisponible pour l'instant, réessayez plus tard",
+		"#%s only takes messages with a link in them":                                            "#%s n'accepte que les messages contenant un lien",
+		"messages in #%s must match %s":                                                          "les messages dans #%s doivent correspondre à %s",
+		"message metadata is %d bytes, the limit is %d":                                          "les métadonnées du message font %d octets, la limite est de %d",
+		"the server is busy right now, try again in a moment":                                    "le serveur est surchargé pour l'instant, réessayez dans un instant",
+		"you've connected from somewhere else, so this session has been closed":                  "vous vous êtes connecté ailleurs, cette session a donc été fermée",
+	},
+	"de": {
+		"#%s doesn't exist, so you've joined #%s": "#%s existiert nicht, daher bist du #%s beigetreten",
+		"Muted %s":      "%s stummgeschaltet",
+		"Unmuted %s":    "Stummschaltung für %s aufgehoben",
+		"%s is offline": "%s ist offline",
+		"%s is offline and will get your message when they next connect":                         "%s ist offline und bekommt deine Nachricht bei der nächsten Verbindung",
+		"the server has no room for #%s right now, try an existing room":                         "der Server hat gerade keinen Platz für #%s, versuche einen bestehenden Raum",
+		"This server is draining for maintenance. Please reconnect to continue chatting.":        "Dieser Server wird für Wartungsarbeiten geleert. Bitte verbinde dich neu, um weiterzuchatten.",
+		"spectators can't send messages":                                                         "Zuschauer können keine Nachrichten senden",
+		"spectators can't pin messages":                                                          "Zuschauer können keine Nachrichten anheften",
+		"only admins, the room's owner and the users the room is restricted to can pin messages": "nur Admins, der Besitzer des Raums und die Nutzer, auf die er beschränkt ist, können Nachrichten anheften",
+		"that message isn't in this room's history":                                              "diese Nachricht ist nicht im Verlauf dieses Raums",
+		"that message isn't pinned":                                                              "diese Nachricht ist nicht angeheftet",
+		"#%s already has %d pinned messages; unpin one first":                                    "#%s hat bereits %d angeheftete Nachrichten; löse zuerst eine",
+		"you've already muted %d people; unmute someone first":                                   "du hast bereits %d Personen stummgeschaltet; hebe zuerst eine Stummschaltung auf",
+		"slow mode is on in #%s: wait %ds before posting again":                                  "Langsamer Modus ist in #%s aktiv: warte %ds, bevor du wieder schreibst",
+		"#%s is busy right now, try again in a moment":                                           "In #%s ist gerade viel los, versuch es gleich noch einmal",
+		"too many ephemeral messages pending, try again later":                                   "zu viele flüchtige Nachrichten ausstehend, versuche es später erneut",
+		"#%s is archived and read-only":                                                          "#%s ist archiviert und schreibgeschützt",
+		"#%s has been archived: you can still read it, but not post":                             "#%s wurde archiviert: du kannst ihn noch lesen, aber nicht mehr schreiben",
+		"#%s is open for messages again":                                                         "#%s nimmt wieder Nachrichten an",
+		"history is unavailable right now, try again later":                                      "der Verlauf ist gerade nicht verfügbar, versuche es später erneut",
+		"#%s only takes messages with a link in them":                                            "#%s nimmt nur Nachrichten mit einem Link an",
+		"messages in #%s must match %s":                                                          "Nachrichten in #%s müssen %s entsprechen",
+		"message metadata is %d bytes, the limit is %d":                                          "die Metadaten der Nachricht sind %d Bytes groß, das Limit ist %d",
+		"the server is busy right now, try again in a moment":                                    "der Server ist gerade ausgelastet, versuch es gleich noch einmal",
+		"you've connected from somewhere else, so this session has been closed":                  "du hast dich woanders verbunden, daher wurde diese Sitzung beendet",
+	},
+}
+
+const defaultLocale = "en"
+
+// parseLocale picks the first supported language from an Accept-Language
+// style list such as "fr-CA,fr;q=0.9,en;q=0.8", falling back to English.
+func parseLocale(s string) string {
+	for _, part := range strings.Split(s, ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if _, ok := catalogs[lang]; ok || lang == defaultLocale {
+			return lang
+		}
+	}
+	return defaultLocale
+}
+
+// localText remembers how a system message was built so each client can
+// get it in its own language.
+type localText struct {
+	format string
+	args   []any
+}
+
+func (t *localText) in(locale string) string {
+	format := t.format
+	if tr, ok := catalogs[locale][format]; ok {
+		format = tr
+	}
+	if t.args == nil {
+		return format
+	}
+	return fmt.Sprintf(format, t.args...)
+}
+
+// localizedError is an error whose text can be translated. kind, if set, is
+// what it wraps for errors.Is.
+type localizedError struct {
+	localText
+	kind error
+}
+
+func (e *localizedError) Unwrap() error {
+	return e.kind
+}
+
+func (e *localizedError) Error() string {
+	return e.in(defaultLocale)
+}
+
+func errorf(format string, args ...any) error {
+	return &localizedError{localText: localText{format, args}}
+}
+
+// wrapf is errorf for an error that wraps kind.
+func wrapf(kind error, format string, args ...any) error {
+	return &localizedError{localText: localText{format, args}, kind: kind}
+}
+
+// systemMessage builds a message from the server with English content that
+// write translates for each client.
+func systemMessage(typ, format string, args ...any) Message {
+	t := &localText{format, args}
+	return Message{
+		Type:      typ,
+		Username:  systemUsername,
+		Content:   t.in(defaultLocale),
+		Timestamp: time.Now(),
+		text:      t,
+	}
+}
+
+// errorMessage is the priority error reply for err. Errors without a
+// translatable format are looked up by their text.
+func errorMessage(err error) Message {
+	var le *localizedError
+	if !errors.As(err, &le) {
+		le = &localizedError{localText: localText{format: err.Error()}}
+	}
+	m := systemMessage(typeError, le.format, le.args...)
+	m.Priority = true
+	return m
+}
@@ -0,0 +1,95 @@
+package chat
+
+import (
+	"slices"
+	"unicode"
+	"unicode/utf8"
+)
+
+func isMentionRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '_' || r == '-' || r == '.'
+}
+
+// parseMentions returns the distinct @names in content, in order of first
+// appearance. A mention must start the text or follow whitespace or
+// punctuation, so addresses like a@b.com aren't picked up.
+func parseMentions(content string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	prev := ' '
+	for i := 0; i < len(content); {
+		r, size := utf8.DecodeRuneInString(content[i:])
+		if r != '@' || isMentionRune(prev) {
+			prev = r
+			i += size
+			continue
+		}
+
+		j := i + size
+		for j < len(content) {
+			nr, nsize := utf8.DecodeRuneInString(content[j:])
+			if !isMentionRune(nr) {
+				break
+			}
+			j += nsize
+		}
+		// Trailing dots are sentence punctuation, not part of the name.
+		for j > i+size && content[j-1] == '.' {
+			j--
+		}
+		if j == i+size {
+			prev = r
+			i += size
+			continue
+		}
+
+		if name := content[i+size : j]; !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		prev, _ = utf8.DecodeLastRuneInString(content[:j])
+		i = j
+	}
+	return names
+}
+
+// resolveMentions keeps the parsed names that belong to users the hub knows
+// about: connected anywhere, or holding an outbox. It must only be called from
+// Run().
+func (h *Hub) resolveMentions(content string) []string {
+	parsed := parseMentions(content)
+	if len(parsed) == 0 {
+		return nil
+	}
+	var mentions []string
+	for _, name := range parsed {
+		_, online := h.users[name]
+		_, away := h.outboxes[name]
+		if online || away {
+			mentions = append(mentions, name)
+		}
+	}
+	return mentions
+}
+
+// notifyMentions tells mentioned users outside the message's room about it,
+// either live on their other connections or through their outbox.
+func (h *Hub) notifyMentions(message Message) {
+	if !h.mentionNotify || len(message.Mentions) == 0 {
+		return
+	}
+
+	notice := message
+	notice.Type = typeMention
+	notice.Priority = true
+	for _, name := range message.Mentions {
+		for _, client := range slices.Clone(h.users[name]) {
+			if client.room != message.Room {
+				h.sendTo(client, notice)
+			}
+		}
+		if o, ok := h.outboxes[name]; ok && o.room != message.Room {
+			o.add(notice, h.outboxSize)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package chat
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// hubMetrics are one hub's Prometheus metrics. Each hub has a registry of
+// its own, so several servers can run in one process and /metrics never
+// exposes whatever else the host registered globally.
+type hubMetrics struct {
+	registry *prometheus.Registry
+	// deliveryLatency measures how long a chat message takes from being
+	// handed to the hub to being written to a client's socket. A high tail
+	// with a normal median points at slow clients; a high median points at
+	// the hub.
+	deliveryLatency  prometheus.Histogram
+	messagesReceived *prometheus.CounterVec
+	messageRunes     prometheus.Histogram
+}
+
+func newHubMetrics(hub *Hub) *hubMetrics {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	factory := promauto.With(reg)
+	m := &hubMetrics{
+		registry: reg,
+		deliveryLatency: factory.NewHistogram(prometheus.HistogramOpts{
+			Name:    "chat_delivery_latency_seconds",
+			Help:    "Time from a message entering the hub to being written to a client.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}),
+		messagesReceived: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "chat_messages_received_total",
+			Help: "Messages accepted from clients, by type.",
+		}, []string{"type"}),
+		messageRunes: factory.NewHistogram(prometheus.HistogramOpts{
+			Name:    "chat_message_runes",
+			Help:    "Length in runes of the content of accepted messages.",
+			Buckets: prometheus.ExponentialBuckets(8, 2, 10),
+		}),
+	}
+
+	// How full the hub's input channels are, to spot a Run() loop that
+	// can't keep up.
+	for name, ch := range map[string]func() int{
+		"broadcast":  func() int { return len(hub.broadcast) },
+		"register":   func() int { return len(hub.register) },
+		"unregister": func() int { return len(hub.unregister) },
+	} {
+		factory.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "chat_hub_channel_depth",
+			Help:        "Messages waiting in a hub input channel.",
+			ConstLabels: prometheus.Labels{"channel": name},
+		}, func() float64 { return float64(ch()) })
+	}
+	return m
+}
+
+// countMessage counts an accepted message and records its length. Only
+// known types become label values, so clients can't grow the series
+// without bound.
+func (hm *hubMetrics) countMessage(m Message) {
+	if m.Content != "" {
+		hm.messageRunes.Observe(float64(utf8.RuneCountInString(m.Content)))
+	}
+	typ := m.Type
+	if typ == "" {
+		typ = typeChat
+	}
+	if !clientTypes[typ] {
+		typ = "other"
+	}
+	hm.messagesReceived.WithLabelValues(typ).Inc()
+}
+
+func (hm *hubMetrics) observeDelivery(received time.Time) {
+	if !received.IsZero() {
+		hm.deliveryLatency.Observe(time.Since(received).Seconds())
+	}
+}
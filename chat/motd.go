@@ -0,0 +1,62 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// motd is the operator's message of the day, shown to each client as it
+// joins. It is only touched from Run().
+type motd struct {
+	text  string
+	until time.Time
+}
+
+func (m motd) active(now time.Time) bool {
+	return m.text != "" && (m.until.IsZero() || now.Before(m.until))
+}
+
+func (m motd) message(now time.Time) Message {
+	return Message{
+		Type:      typeSystem,
+		Username:  systemUsername,
+		Content:   m.text,
+		Timestamp: now,
+	}
+}
+
+// serveMOTD sets the message of the day, e.g.
+// {"text":"Maintenance at 22:00 UTC","until":"2026-01-02T23:00:00Z","broadcast":true}.
+// until is optional; broadcast also sends it to everyone already connected.
+// Empty text clears it.
+func serveMOTD(hub *Hub, audit *auditLog, w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Text      string    `json:"text"`
+		Until     time.Time `json:"until"`
+		Broadcast bool      `json:"broadcast"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if !body.Until.IsZero() && !body.Until.After(time.Now()) {
+		http.Error(w, "until is in the past", http.StatusBadRequest)
+		return
+	}
+
+	m := motd{text: body.Text, until: body.Until}
+	if !hub.do(func() { hub.motd = m }) {
+		http.Error(w, "hub stopped", http.StatusServiceUnavailable)
+		return
+	}
+	if body.Text == "" {
+		audit.record(r, "motd-clear", "")
+	} else {
+		audit.record(r, "motd", body.Text)
+	}
+	if body.Broadcast && body.Text != "" {
+		hub.announce(m.message(time.Now()))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,61 @@
+package chat
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// NameGenerator makes up a name for a user who connects without one. The hub
+// checks it against connected users and asks again on a collision, so it
+// only needs to vary, not to be unique. It is only called from the hub's
+// goroutine.
+type NameGenerator func() string
+
+var (
+	nameAdjectives = []string{"brave", "calm", "clever", "eager", "fuzzy", "gentle", "happy", "jolly", "kind", "lively", "lucky", "merry", "nimble", "proud", "quick", "quiet", "shy", "sunny", "swift", "witty"}
+	nameAnimals    = []string{"badger", "beaver", "crane", "dolphin", "falcon", "fox", "gecko", "heron", "koala", "lynx", "meerkat", "otter", "owl", "panda", "puffin", "rabbit", "seal", "tiger", "walrus", "zebra"}
+)
+
+// friendlyName is the default generator, e.g. "brave-otter-42".
+func friendlyName() string {
+	adjective := nameAdjectives[rand.IntN(len(nameAdjectives))]
+	animal := nameAnimals[rand.IntN(len(nameAnimals))]
+	return fmt.Sprintf("%s-%s-%d", adjective, animal, rand.IntN(100))
+}
+
+// numberedName is the old scheme, e.g. "User417".
+func numberedName() string {
+	return fmt.Sprintf("User%d", time.Now().Unix()%1000)
+}
+
+// nameGenerators are the generators -anonymous-names can pick. Programs
+// with their own scheme set Config.NameGenerator instead.
+var nameGenerators = map[string]NameGenerator{
+	"friendly": friendlyName,
+	"numbered": numberedName,
+}
+
+// maxNameAttempts bounds how often the generator is asked for a free name
+// before a number is tacked on to one.
+const maxNameAttempts = 10
+
+// anonymousName picks a name no connected user has. Two anonymous users
+// connecting at the same moment could still get the same name, since it
+// isn't reserved until the client registers. It must only be called from
+// Run().
+func (h *Hub) anonymousName() string {
+	var name string
+	for range maxNameAttempts {
+		name = h.generateName()
+		if _, taken := h.users[name]; !taken {
+			return name
+		}
+	}
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s-%d", name, n)
+		if _, taken := h.users[candidate]; !taken {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package chat
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsSubjectPrefix is followed by the room name; room names are always
+// valid subject tokens.
+const natsSubjectPrefix = "chat.room."
+
+// natsBroker relays room messages between instances over NATS, one subject
+// per room.
+type natsBroker struct {
+	conn *nats.Conn
+	sub  *nats.Subscription
+}
+
+func newNATSBroker(url string) (*natsBroker, error) {
+	// Keep reconnecting for as long as the process lives; NATS restores
+	// subscriptions and buffers publishes while it is away.
+	conn, err := nats.Connect(url,
+		nats.Name("simple_chat"),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(time.Second),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("NATS disconnected: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			log.Printf("NATS reconnected to %s", c.ConnectedUrl())
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &natsBroker{conn: conn}, nil
+}
+
+func (b *natsBroker) Publish(room string, m Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return b.conn.Publish(natsSubjectPrefix+room, data)
+}
+
+func (b *natsBroker) Subscribe(fn func(room string, m Message)) error {
+	sub, err := b.conn.Subscribe(natsSubjectPrefix+"*", func(msg *nats.Msg) {
+		var m Message
+		if err := json.Unmarshal(msg.Data, &m); err != nil {
+			log.Printf("Ignoring malformed relay message: %v", err)
+			return
+		}
+		fn(strings.TrimPrefix(msg.Subject, natsSubjectPrefix), m)
+	})
+	if err != nil {
+		return err
+	}
+	b.sub = sub
+	return b.conn.Flush()
+}
+
+// Close stops the subscription, flushes pending publishes and disconnects.
+func (b *natsBroker) Close() error {
+	return b.conn.Drain()
+}
@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var blankLineRuns = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+\n`)
+
+// normalizeContent cleans up message content according to the hub's
+// Normalize* settings. Emoji and other printable characters are untouched.
+func (h *Hub) normalizeContent(s string) string {
+	if h.cfg.NormalizeControl {
+		s = strings.ReplaceAll(s, "\r\n", "\n")
+		s = strings.Map(func(r rune) rune {
+			if r == '\n' || r == '\t' || !unicode.IsControl(r) {
+				return r
+			}
+			return -1
+		}, s)
+	}
+	if h.cfg.NormalizeNFC {
+		s = norm.NFC.String(s)
+	}
+	if h.cfg.NormalizeBlankLines {
+		s = blankLineRuns.ReplaceAllString(s, "\n\n")
+	}
+	if h.cfg.NormalizeTrim {
+		s = strings.TrimSpace(s)
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package chat
+
+import "time"
+
+// outbox collects the messages a user missed in their room after
+// disconnecting, so a quick reconnect can pick up where it left off.
+type outbox struct {
+	room     string
+	messages []Message
+	expires  time.Time
+}
+
+func (o *outbox) add(m Message, limit int) {
+	if len(o.messages) >= limit {
+		o.messages = o.messages[1:]
+	}
+	m.received = time.Time{}
+	o.messages = append(o.messages, m)
+}
+
+// openOutbox starts queuing for a client that just went away, unless its
+// user is still connected elsewhere and so isn't missing anything. It must
+// only be called from Run(), after the client has been removed.
+func (h *Hub) openOutbox(client *Client) {
+	if h.outboxGrace <= 0 || len(h.users[client.username]) > 0 {
+		return
+	}
+	h.outboxes[client.username] = &outbox{
+		room:    client.room,
+		expires: time.Now().Add(h.outboxGrace),
+	}
+}
+
+// queueOutboxes appends a room message to the outboxes of users who were in
+// that room when they disconnected.
+func (h *Hub) queueOutboxes(message Message) {
+	now := time.Now()
+	for _, o := range h.outboxes {
+		if o.room == message.Room && now.Before(o.expires) {
+			o.add(message, h.outboxSize)
+		}
+	}
+}
+
+// takeOutbox removes and returns the pending messages for a reconnecting
+// client, if it came back to the same room within the grace period.
+func (h *Hub) takeOutbox(client *Client) ([]Message, bool) {
+	o, ok := h.outboxes[client.username]
+	if !ok {
+		return nil, false
+	}
+	delete(h.outboxes, client.username)
+	if o.room != client.room || time.Now().After(o.expires) {
+		return nil, false
+	}
+	return o.messages, true
+}
+
+func (h *Hub) expireOutboxes() {
+	now := time.Now()
+	for name, o := range h.outboxes {
+		if now.After(o.expires) {
+			delete(h.outboxes, name)
+		}
+	}
+}
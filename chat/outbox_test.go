@@ -0,0 +1,42 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOpenOutboxOnlyForLastConnection(t *testing.T) {
+	tests := []struct {
+		name   string
+		grace  time.Duration
+		conns  int
+		remove int
+		want   bool
+	}{
+		{"only connection", time.Minute, 1, 1, true},
+		{"one of two tabs", time.Minute, 2, 1, false},
+		{"both tabs", time.Minute, 2, 2, true},
+		{"outbox disabled", 0, 1, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.OutboxGrace = tt.grace
+			h := NewHub(cfg)
+			var clients []*Client
+			for range tt.conns {
+				c := &Client{hub: h, username: "alice", room: defaultRoom, send: make(chan Message, 1)}
+				h.clients[c] = true
+				h.addUserConn(c)
+				clients = append(clients, c)
+			}
+			for _, c := range clients[:tt.remove] {
+				h.removeClient(c)
+				h.openOutbox(c)
+			}
+			if _, got := h.outboxes["alice"]; got != tt.want {
+				t.Errorf("outbox open = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
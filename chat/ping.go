@@ -0,0 +1,56 @@
+package chat
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const writeWait = 10 * time.Second
+
+// rttWeight is how much each new sample moves the rolling average.
+const rttWeight = 0.2
+
+// ping sends a ping carrying the time it was sent, so the pong tells us the
+// round trip. It is safe to call alongside other writes.
+func (c *Client) ping() error {
+	payload := strconv.FormatInt(time.Now().UnixNano(), 10)
+	return c.conn.WriteControl(websocket.PingMessage, []byte(payload), time.Now().Add(writeWait))
+}
+
+// handlePong runs in readPump. Each pong pushes the read deadline out and
+// feeds the round-trip average.
+func (c *Client) handlePong(stopping func() bool) func(string) error {
+	return func(data string) error {
+		if stopping() {
+			// Leave the shutdown deadline alone.
+			return nil
+		}
+		now := time.Now()
+		c.conn.SetReadDeadline(now.Add(c.hub.cfg.PongTimeout))
+
+		sent, err := strconv.ParseInt(data, 10, 64)
+		if err != nil {
+			return nil
+		}
+		rtt := now.Sub(time.Unix(0, sent))
+		avg := time.Duration(c.rtt.Load())
+		if avg == 0 {
+			avg = rtt
+		} else {
+			avg += time.Duration(rttWeight * float64(rtt-avg))
+		}
+		c.rtt.Store(int64(avg))
+
+		if c.hub.cfg.PushRTT {
+			c.command(Message{
+				Type:      typeLatency,
+				Username:  systemUsername,
+				RTTMillis: avg.Milliseconds(),
+				Timestamp: now,
+			})
+		}
+		return nil
+	}
+}
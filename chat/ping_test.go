@@ -0,0 +1,55 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPongTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer bool
+		wait   time.Duration
+		kept   bool
+	}{
+		{"silent peer", false, time.Second, false},
+		{"responsive peer", true, 500 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.PingInterval = 50 * time.Millisecond
+			cfg.PongTimeout = 150 * time.Millisecond
+			ts := startServer(t, cfg)
+			c := ts.dial(t, user("alice"))
+			if !tt.answer {
+				c.conn.SetPingHandler(func(string) error { return nil })
+			}
+
+			// Reading is what answers pings, so keep reading.
+			c.conn.SetReadDeadline(time.Now().Add(tt.wait))
+			var err error
+			for err == nil {
+				_, _, err = c.conn.ReadMessage()
+			}
+			if isTimeout(err) != tt.kept {
+				t.Fatalf("after %v: read error %v, want connection kept = %v", tt.wait, err, tt.kept)
+			}
+
+			// The socket closing isn't enough: the hub has to have let
+			// go of the client too. Unregistering is asynchronous, so
+			// give it a moment.
+			h := ts.srv.hub
+			var registered bool
+			for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+				h.do(func() { registered = len(h.users["alice"]) > 0 })
+				if registered == tt.kept || time.Now().After(deadline) {
+					break
+				}
+			}
+			if registered != tt.kept {
+				t.Errorf("alice registered with the hub = %v, want %v", registered, tt.kept)
+			}
+		})
+	}
+}
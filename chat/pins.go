@@ -0,0 +1,162 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+)
+
+var (
+	errSpectatorPin = errors.New("spectators can't pin messages")
+	errPinForbidden = errors.New("only admins, the room's owner and the users the room is restricted to can pin messages")
+	errNotPinnable  = errors.New("that message isn't in this room's history")
+	errNotPinned    = errors.New("that message isn't pinned")
+)
+
+// pin is a message pinned to the top of a room. The message is copied so it
+// stays pinned after it scrolls out of history.
+type pin struct {
+	message  Message
+	by       string
+	pinnedAt time.Time
+}
+
+// event is the pin notice sent to clients: Username is who pinned it, ID,
+// Target and Content identify the pinned message and its author.
+func (p pin) event(typ string) Message {
+	return Message{
+		Type:      typ,
+		Username:  p.by,
+		Room:      p.message.Room,
+		ID:        p.message.ID,
+		Target:    p.message.Username,
+		Content:   p.message.Content,
+		Timestamp: p.pinnedAt,
+	}
+}
+
+// canPin reports whether a client may pin and unpin in its room: admins,
+// the user who opened the room, and users the room ACL lists for it. It
+// must only be called from Run().
+func (h *Hub) canPin(client *Client) bool {
+	if client.admin || h.roomACL[client.room][client.username] {
+		return true
+	}
+	r, ok := h.rooms[client.room]
+	return ok && r.owner != "" && r.owner == client.username
+}
+
+// togglePin pins or unpins a message in the client's room and tells the
+// room. A message to pin has to be found in the store first, so errors from
+// then on go to the client directly. It must only be called from Run().
+func (h *Hub) togglePin(client *Client, typ, id string) error {
+	if client.spectator {
+		return errSpectatorPin
+	}
+	if !h.canPin(client) {
+		return errPinForbidden
+	}
+	pins := h.pins[client.room]
+	i := slices.IndexFunc(pins, func(p pin) bool { return p.message.ID == id })
+
+	if typ == typeUnpin {
+		if i < 0 {
+			return errNotPinned
+		}
+		p := pins[i]
+		p.by, p.pinnedAt = client.username, time.Now()
+		h.pins[client.room] = slices.Delete(pins, i, i+1)
+		if r, ok := h.rooms[client.room]; ok {
+			h.deliver(r, p.event(typeUnpin))
+		}
+		return nil
+	}
+	if i >= 0 {
+		return nil
+	}
+	if len(pins) >= h.maxPins {
+		return h.pinsFull(client.room)
+	}
+	var found Message
+	h.storeThen(func(s MessageStore) error {
+		backlog, err := s.Recent(client.room)
+		if err != nil {
+			return err
+		}
+		j := slices.IndexFunc(backlog, func(m Message) bool { return m.ID == id })
+		if j < 0 {
+			return errNotPinnable
+		}
+		found = backlog[j]
+		return nil
+	}, func(err error) {
+		if err == nil {
+			err = h.addPin(client, found)
+		}
+		if err != nil {
+			h.sendTo(client, errorMessage(err))
+		}
+	})
+	return nil
+}
+
+// addPin pins a message found in the store. Other pins may have come and
+// gone while the store was read, so the limits are checked again. It must
+// only be called from Run().
+func (h *Hub) addPin(client *Client, m Message) error {
+	pins := h.pins[client.room]
+	if slices.ContainsFunc(pins, func(p pin) bool { return p.message.ID == m.ID }) {
+		return nil
+	}
+	if len(pins) >= h.maxPins {
+		return h.pinsFull(client.room)
+	}
+	p := pin{message: m, by: client.username, pinnedAt: time.Now()}
+	h.pins[client.room] = append(pins, p)
+	if r, ok := h.rooms[client.room]; ok {
+		h.deliver(r, p.event(typePin))
+	}
+	return nil
+}
+
+func (h *Hub) pinsFull(room string) error {
+	return errorf("#%s already has %d pinned messages; unpin one first", room, h.maxPins)
+}
+
+// unpinMessage drops a message from a room's pins without telling anyone,
+// for messages that are being deleted anyway. It must only be called from
+// Run().
+func (h *Hub) unpinMessage(room, id string) {
+	h.pins[room] = slices.DeleteFunc(h.pins[room], func(p pin) bool { return p.message.ID == id })
+	if len(h.pins[room]) == 0 {
+		delete(h.pins, room)
+	}
+}
+
+// servePins lists a room's pinned messages, oldest pin first, to callers
+// allowed into the room.
+func servePins(hub *Hub, auth *tokenAuth, w http.ResponseWriter, r *http.Request) {
+	room := r.PathValue("room")
+	if !validRoomName(room) {
+		http.Error(w, "invalid room name", http.StatusBadRequest)
+		return
+	}
+	if !checkRoomRead(hub, auth, room, w, r) {
+		return
+	}
+
+	messages := []Message{}
+	if !hub.do(func() {
+		for _, p := range hub.pins[room] {
+			messages = append(messages, p.message)
+		}
+	}) {
+		http.Error(w, "hub stopped", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
@@ -0,0 +1,57 @@
+package chat
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWhoCanPin(t *testing.T) {
+	tests := []struct {
+		name   string
+		room   string
+		first  string // who opens the room before the pinner joins
+		pinner string
+		admin  bool
+		want   bool
+	}{
+		{"owner of a new room", "fresh", "", "alice", false, true},
+		{"someone else's room", "fresh", "bob", "alice", false, false},
+		{"default room", defaultRoom, "", "alice", false, false},
+		{"admin", defaultRoom, "", "alice", true, true},
+		{"listed in the room ACL", "staff", "dave", "carol", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acl := filepath.Join(t.TempDir(), "acl")
+			if err := os.WriteFile(acl, []byte("staff=carol,dave\n"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			cfg := DefaultConfig()
+			cfg.UsernameHeader = "X-User"
+			cfg.AdminToken = "secret"
+			cfg.RoomACLFile = acl
+			ts := startServer(t, cfg)
+
+			room := url.Values{"room": {tt.room}}
+			if tt.first != "" {
+				first := ts.dialHeader(t, room, http.Header{"X-User": {tt.first}})
+				first.await("the first joiner's presence", isType(typePresence))
+			}
+			header := http.Header{"X-User": {tt.pinner}}
+			if tt.admin {
+				header.Set("Authorization", "Bearer secret")
+			}
+			pinner := ts.dialHeader(t, room, header)
+			pinner.send(Message{Content: "pin me"})
+			m := pinner.await("the message to pin", isChat("pin me"))
+			pinner.send(Message{Type: typePin, ID: m.ID})
+			got := pinner.await("the pin or an error", func(m Message) bool { return m.Type == typePin || m.Type == typeError })
+			if (got.Type == typePin) != tt.want {
+				t.Errorf("pin allowed = %v, want %v (%s)", got.Type == typePin, tt.want, got.Content)
+			}
+		})
+	}
+}
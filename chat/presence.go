@@ -0,0 +1,52 @@
+package chat
+
+import (
+	"sort"
+	"time"
+)
+
+// presenceChanged is called from Run() after a join or leave. Small rooms get
+// the update right away; busy ones are marked dirty and picked up by the
+// presence ticker so high churn doesn't flood every client.
+func (h *Hub) presenceChanged(r *room) {
+	if h.presenceInterval <= 0 || r.participants() <= h.presenceBatchThreshold {
+		h.broadcastPresence(r)
+		return
+	}
+	r.presenceDirty = true
+}
+
+func (h *Hub) broadcastPresence(r *room) {
+	r.presenceDirty = false
+	h.deliver(r, Message{
+		Type:      typePresence,
+		Username:  systemUsername,
+		Room:      r.name,
+		Online:    r.onlineUsers(),
+		Timestamp: time.Now(),
+	})
+}
+
+func (r *room) onlineUsers() []string {
+	seen := make(map[string]bool, len(r.clients))
+	names := make([]string, 0, len(r.clients))
+	for client := range r.clients {
+		if !client.spectator && !seen[client.username] {
+			seen[client.username] = true
+			names = append(names, client.username)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// participants counts the room's connections, leaving out spectators.
+func (r *room) participants() int {
+	n := 0
+	for client := range r.clients {
+		if !client.spectator {
+			n++
+		}
+	}
+	return n
+}
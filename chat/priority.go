@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"log"
+	"time"
+)
+
+// Priority messages are system alerts, mention notices and error replies:
+// things a client must see even when it is behind on ordinary chat. They go
+// through a separate, small per-client queue that writePump always drains
+// first, and instead of being dropped when that queue is full, they wait up
+// to priorityDeadline for room before the client is given up on. The waiting
+// happens on a per-client goroutine, never in Run(). Ordinary chat keeps
+// best-effort delivery and is dropped when the send buffer fills. Clients
+// can't mark their own messages as priority.
+const (
+	priorityBufferSize = 16
+	priorityDeadline   = 100 * time.Millisecond
+)
+
+// sendPriority delivers a priority message. Once the priority queue has
+// been full, it and everything after it go through the client's overflow,
+// in order, for waitPriority to deliver; only a client whose overflow fills
+// up too is dropped here. It reports false if the client was dropped. It
+// must only be called from Run().
+func (h *Hub) sendPriority(client *Client, message Message) bool {
+	if client.overflow == nil {
+		select {
+		case client.priority <- message:
+			return true
+		default:
+		}
+		client.overflow = make(chan Message, priorityBufferSize)
+		go client.waitPriority(client.overflow)
+	}
+	select {
+	case client.overflow <- message:
+		return true
+	default:
+		h.removeClient(client)
+		return false
+	}
+}
+
+// waitPriority moves messages from overflow into the priority queue, giving
+// the client up to priorityDeadline to make room for each one before asking
+// the hub to drop it.
+func (c *Client) waitPriority(overflow <-chan Message) {
+	for {
+		var message Message
+		select {
+		case message = <-overflow:
+		case <-c.writeDone:
+			return
+		case <-c.hub.done:
+			return
+		}
+
+		timer := time.NewTimer(priorityDeadline)
+		select {
+		case c.priority <- message:
+			timer.Stop()
+		case <-timer.C:
+			log.Printf("Dropping %s (%s): priority queue still full after %v", c.username, c.id, priorityDeadline)
+			c.leave()
+			return
+		case <-c.writeDone:
+			timer.Stop()
+			return
+		case <-c.hub.done:
+			timer.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSendPriorityNeverWaits(t *testing.T) {
+	tests := []struct {
+		name     string
+		queued   int
+		overflow bool // whether the overflow is already full as well
+		drain    bool // whether writePump makes room in time
+		want     bool
+		dropped  bool
+	}{
+		{"empty queue", 0, false, false, true, false},
+		{"room for one more", priorityBufferSize - 1, false, false, true, false},
+		{"full queue drained in time", priorityBufferSize, false, true, true, false},
+		{"full queue never drained", priorityBufferSize, false, false, true, true},
+		{"full overflow", priorityBufferSize, true, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(DefaultConfig())
+			t.Cleanup(h.Stop)
+			c := &Client{hub: h, username: "alice", room: defaultRoom, send: make(chan Message, 1), priority: make(chan Message, priorityBufferSize)}
+			h.clients[c] = true
+			h.addUserConn(c)
+			for range tt.queued {
+				c.priority <- Message{}
+			}
+			if tt.overflow {
+				c.overflow = make(chan Message, priorityBufferSize)
+				for range priorityBufferSize {
+					c.overflow <- Message{}
+				}
+			}
+
+			start := time.Now()
+			got := h.sendPriority(c, Message{Type: typeError, Priority: true})
+			if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+				t.Errorf("sendPriority took %v", elapsed)
+			}
+			if got != tt.want {
+				t.Errorf("sendPriority = %v, want %v", got, tt.want)
+			}
+			if tt.drain {
+				<-c.priority
+			}
+
+			dropped := !h.clients[c]
+			if !dropped {
+				// Give waitPriority its deadline to ask for the drop.
+				select {
+				case <-h.unregister:
+					dropped = true
+				case <-time.After(2 * priorityDeadline):
+				}
+			}
+			if dropped != tt.dropped {
+				t.Errorf("client dropped = %v, want %v", dropped, tt.dropped)
+			}
+			if tt.want && !tt.dropped {
+				var last Message
+				for len(c.priority) > 0 {
+					last = <-c.priority
+				}
+				if last.Type != typeError {
+					t.Errorf("last priority message = %+v, want the error", last)
+				}
+			}
+		})
+	}
+}
+
+// TestMentionKeepsChatInOrder checks that a mention doesn't let the chat
+// message overtake what the client already has queued, during a replay or
+// not, and that the highlight arrives separately.
+func TestMentionKeepsChatInOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		replaying bool
+	}{
+		{"live", false},
+		{"during replay", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(DefaultConfig())
+			c := &Client{hub: h, username: "alice", room: defaultRoom, send: make(chan Message, 8), priority: make(chan Message, priorityBufferSize)}
+			h.clients[c] = true
+			h.addUserConn(c)
+			r := h.room(defaultRoom)
+			r.clients[c] = true
+			c.replaying = tt.replaying
+
+			h.deliver(r, Message{ID: "1", Type: typeChat, Username: "bob", Room: defaultRoom, Content: "hi"})
+			h.deliver(r, Message{ID: "2", Type: typeChat, Username: "bob", Room: defaultRoom, Content: "@alice look", Mentions: []string{"alice"}})
+
+			var chat []string
+			if tt.replaying {
+				for _, m := range c.held {
+					chat = append(chat, m.ID)
+				}
+			} else {
+				for len(c.send) > 0 {
+					chat = append(chat, (<-c.send).ID)
+				}
+			}
+			if !slices.Equal(chat, []string{"1", "2"}) {
+				t.Errorf("chat queued = %v, want [1 2]", chat)
+			}
+			if len(c.priority) != 1 {
+				t.Fatalf("priority queue holds %d messages, want 1", len(c.priority))
+			}
+			if got := <-c.priority; got.Type != typeMention || got.ID != "2" || got.Content != "" {
+				t.Errorf("priority message = %+v, want a bare mention of 2", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package chat
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+)
+
+const maxAvatarURLLength = 512
+
+var validColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`).MatchString
+
+var (
+	errInvalidColor  = errors.New("color must look like #1a2b3c")
+	errInvalidAvatar = errors.New("avatarUrl must be an https URL")
+)
+
+// validAvatarURL accepts only plain https URLs, so nothing a client sends
+// can become a script or data URL in someone else's page.
+func validAvatarURL(raw string) bool {
+	if len(raw) > maxAvatarURLLength {
+		return false
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "https" && u.Host != "" && u.User == nil
+}
+
+// parseProfile reads the optional color and avatarUrl connect parameters.
+// Either may be empty; a present but invalid one is an error.
+func parseProfile(q url.Values) (color, avatarURL string, err error) {
+	color, avatarURL = q.Get("color"), q.Get("avatarUrl")
+	if color != "" && !validColor(color) {
+		return "", "", errInvalidColor
+	}
+	if avatarURL != "" && !validAvatarURL(avatarURL) {
+		return "", "", errInvalidAvatar
+	}
+	return color, avatarURL, nil
+}
@@ -0,0 +1,38 @@
+package chat
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxUsernameBytes = 64
+
+var (
+	errNoUsernameHeader = errors.New("missing authenticated username")
+	errUntrustedProxy   = errors.New("request did not come through a trusted proxy")
+)
+
+// headerUsername returns the username a proxy put in header. With proxies
+// set, requests that came from anywhere else are refused.
+func headerUsername(header string, proxies []netip.Prefix, r *http.Request) (string, error) {
+	if len(proxies) > 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		addr, err := netip.ParseAddr(host)
+		if err != nil || !trustedProxy(proxies, addr) {
+			return "", errUntrustedProxy
+		}
+	}
+	name := strings.TrimSpace(r.Header.Get(header))
+	if name == "" || len(name) > maxUsernameBytes || !utf8.ValidString(name) || strings.ContainsFunc(name, unicode.IsControl) {
+		return "", errNoUsernameHeader
+	}
+	return name, nil
+}
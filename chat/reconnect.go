@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// closeReason is the reason sent in the close frame when the server hangs
+// up. It tells the client how long to wait and which message to resume
+// after; close reasons are limited to 123 bytes, which this stays well under.
+// The delay is jittered by up to half again so clients dropped together
+// don't all come back together.
+func closeReason(backoff time.Duration, lastID string) string {
+	retry := backoff.Milliseconds()
+	if retry > 1 {
+		retry += rand.Int64N(retry / 2)
+	}
+	reason := fmt.Sprintf("retry=%d", retry)
+	if lastID != "" {
+		reason += ";resume=" + lastID
+	}
+	return reason
+}
+
+// resumeAfter returns the messages that follow id in backlog. If id isn't
+// there, it has aged out of history and the whole backlog is returned.
+func resumeAfter(backlog []Message, id string) []Message {
+	for i, m := range backlog {
+		if m.ID == id {
+			return backlog[i+1:]
+		}
+	}
+	return backlog
+}
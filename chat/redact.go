@@ -0,0 +1,19 @@
+package chat
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// logged describes a message for the log: its ID, room and length, plus
+// its text only when LogContent is set.
+func (h *Hub) logged(m Message) string {
+	where := "#" + m.Room
+	if m.Type == typeDirect {
+		where = "dm to " + m.To
+	}
+	if h.cfg.LogContent {
+		return fmt.Sprintf("%s (%s): %q", m.ID, where, m.Content)
+	}
+	return fmt.Sprintf("%s (%s, %d characters)", m.ID, where, utf8.RuneCountInString(m.Content))
+}
@@ -0,0 +1,186 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisTimeout = 2 * time.Second
+	redisChannel = "chat:broadcast"
+)
+
+// redisStore keeps room history in Redis lists so every instance sees the
+// same backlog.
+type redisStore struct {
+	client *redis.Client
+	sizes  historySizes
+	inbox  inboxLimits
+}
+
+func newRedisStore(url string, sizes historySizes, inbox inboxLimits) (*redisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &redisStore{client: client, sizes: sizes, inbox: inbox}, nil
+}
+
+func redisRoomKey(room string) string {
+	return "chat:room:" + room + ":messages"
+}
+
+func (s *redisStore) Append(room string, m Message) error {
+	size := s.sizes.keep(room)
+	if size == 0 {
+		return nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	key := redisRoomKey(room)
+	pipe := s.client.TxPipeline()
+	pipe.RPush(ctx, key, data)
+	pipe.LTrim(ctx, key, int64(-size), -1)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) Recent(room string) ([]Message, error) {
+	size := s.sizes.forRoom(room)
+	if size == 0 {
+		return []Message{}, nil
+	}
+	return s.lrange(room, int64(-size))
+}
+
+// Before reads the room's whole scrollback to find id: list entries can
+// only be looked up by position.
+func (s *redisStore) Before(room, id string, n int) ([]Message, error) {
+	messages, err := s.lrange(room, 0)
+	if err != nil {
+		return nil, err
+	}
+	return pageBefore(messages, id, n)
+}
+
+// lrange reads a room's stored messages from position start to the newest.
+func (s *redisStore) lrange(room string, start int64) ([]Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	vals, err := s.client.LRange(ctx, redisRoomKey(room), start, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(vals))
+	for _, v := range vals {
+		var m Message
+		if err := json.Unmarshal([]byte(v), &m); err != nil {
+			log.Printf("Skipping unreadable history entry in #%s: %v", room, err)
+			continue
+		}
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
+
+func (s *redisStore) Remove(room, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	key := redisRoomKey(room)
+	vals, err := s.client.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	for _, v := range vals {
+		var m Message
+		if json.Unmarshal([]byte(v), &m) == nil && m.ID == id {
+			return s.client.LRem(ctx, key, 1, v).Err()
+		}
+	}
+	return nil
+}
+
+// Rooms lists the rooms with stored history by scanning for their keys.
+func (s *redisStore) Rooms() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	var rooms []string
+	iter := s.client.Scan(ctx, 0, redisRoomKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		room := strings.TrimSuffix(strings.TrimPrefix(iter.Val(), "chat:room:"), ":messages")
+		rooms = append(rooms, room)
+	}
+	return rooms, iter.Err()
+}
+
+// redisBroker relays room messages between instances over a Redis pub/sub
+// channel.
+type redisBroker struct {
+	client *redis.Client
+	sub    *redis.PubSub
+}
+
+func newRedisBroker(client *redis.Client) *redisBroker {
+	return &redisBroker{client: client}
+}
+
+func (b *redisBroker) Publish(room string, m Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return b.client.Publish(ctx, redisChannel, data).Err()
+}
+
+func (b *redisBroker) Subscribe(fn func(room string, m Message)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	b.sub = b.client.Subscribe(context.Background(), redisChannel)
+	if _, err := b.sub.Receive(ctx); err != nil {
+		b.sub.Close()
+		return err
+	}
+
+	go func() {
+		// The channel is closed by Close; go-redis resubscribes by
+		// itself if the connection drops in between.
+		for msg := range b.sub.Channel() {
+			var m Message
+			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+				log.Printf("Ignoring malformed relay message: %v", err)
+				continue
+			}
+			fn(m.Room, m)
+		}
+	}()
+	return nil
+}
+
+func (b *redisBroker) Close() error {
+	if b.sub != nil {
+		return b.sub.Close()
+	}
+	return nil
+}
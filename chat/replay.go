@@ -0,0 +1,43 @@
+package chat
+
+import "time"
+
+// replayLimiter bounds how many joining clients are having history
+// replayed at the same time, so a reconnect storm after a restart doesn't
+// fill every send buffer at once. A nil limiter never blocks.
+type replayLimiter chan struct{}
+
+func newReplayLimiter(n int) replayLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(replayLimiter, n)
+}
+
+// replayWait is how long a joining client waits for a replay slot before
+// being told the server is busy.
+const replayWait = 10 * time.Second
+
+// acquire waits up to wait for a free slot. It fails with errShuttingDown if
+// done closes first and with errServerBusy if the wait runs out.
+func (l replayLimiter) acquire(done <-chan struct{}, wait time.Duration) error {
+	if l == nil {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-done:
+		return errShuttingDown
+	case <-timer.C:
+		return serverBusyError()
+	}
+}
+
+func (l replayLimiter) release() {
+	if l != nil {
+		<-l
+	}
+}
@@ -0,0 +1,180 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestJoinWhileMessagesArrive has a client join partway through a stream of
+// messages. Between the replayed history and live delivery it must see
+// every message exactly once, in order.
+func TestJoinWhileMessagesArrive(t *testing.T) {
+	tests := []struct {
+		total, before int
+	}{
+		{total: 30, before: 0},
+		{total: 30, before: 15},
+		{total: 30, before: 30},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d of %d before", tt.before, tt.total), func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.RoomRate = 0
+			ts := startServer(t, cfg)
+			alice := ts.dial(t, user("alice"))
+			alice.await("alice's presence", isPresence("alice"))
+
+			for i := range tt.before {
+				alice.send(Message{Content: strconv.Itoa(i)})
+			}
+			rest := make(chan struct{})
+			go func() {
+				defer close(rest)
+				for i := tt.before; i < tt.total; i++ {
+					if alice.conn.WriteJSON(Message{Content: strconv.Itoa(i)}) != nil {
+						return
+					}
+				}
+			}()
+			bob := ts.dial(t, user("bob"))
+			<-rest
+
+			for want := 0; want < tt.total; want++ {
+				got := bob.await("the next message", isType(typeChat))
+				if got.Content != strconv.Itoa(want) {
+					t.Fatalf("got message %s, want %d", got.Content, want)
+				}
+			}
+		})
+	}
+}
+
+func TestJoinReplay(t *testing.T) {
+	now := time.Now()
+	seed := []Message{
+		{ID: "old", Type: typeChat, Username: "alice", Room: defaultRoom, Content: "old", Timestamp: now.Add(-48 * time.Hour)},
+		{ID: "m1", Type: typeChat, Username: "alice", Room: defaultRoom, Content: "m1", Timestamp: now.Add(-time.Minute)},
+		{ID: "m2", Type: typeChat, Username: "alice", Room: defaultRoom, Content: "m2", Timestamp: now},
+	}
+	tests := []struct {
+		name     string
+		maxAge   time.Duration
+		resume   string
+		pinned   bool
+		want     []string
+		wantPins []string
+	}{
+		{"fresh join", 24 * time.Hour, "", false, []string{"m1", "m2"}, nil},
+		{"no age limit", 0, "", false, []string{"old", "m1", "m2"}, nil},
+		{"resume", 24 * time.Hour, "m1", false, []string{"m2"}, nil},
+		{"resume from the newest", 24 * time.Hour, "m2", false, nil, nil},
+		{"resume from an unknown message", 24 * time.Hour, "gone", false, []string{"m1", "m2"}, nil},
+		{"pins", 24 * time.Hour, "", true, []string{"m1", "m2"}, []string{"m1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.ReplayMaxAge = tt.maxAge
+			ts := startServer(t, cfg)
+			h := ts.srv.hub
+			h.readStore(func(s MessageStore) {
+				for _, m := range seed {
+					s.Append(defaultRoom, m)
+				}
+			})
+			h.do(func() {
+				if tt.pinned {
+					h.pins[defaultRoom] = []pin{{message: seed[1], by: "alice", pinnedAt: now}}
+				}
+			})
+
+			query := user("bob")
+			if tt.resume != "" {
+				query.Set("resume", tt.resume)
+			}
+			bob := ts.dial(t, query)
+			// Presence follows the whole replay.
+			var got, pins []string
+			bob.await("the end of the replay", func(m Message) bool {
+				switch m.Type {
+				case typeChat:
+					got = append(got, m.ID)
+				case typePin:
+					pins = append(pins, m.ID)
+				}
+				return m.Type == typePresence
+			})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("replayed %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(pins, tt.wantPins) {
+				t.Errorf("replayed pins %v, want %v", pins, tt.wantPins)
+			}
+		})
+	}
+}
+
+// TestReplaySlotReleased lets one replay run at a time and checks that a
+// client who joined, quiet room or not, doesn't keep the next one waiting.
+func TestReplaySlotReleased(t *testing.T) {
+	tests := []struct {
+		name    string
+		history int
+	}{
+		{"empty room", 0},
+		{"room with history", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.MaxConcurrentReplays = 1
+			cfg.RoomRate = 0
+			// Batch every presence update, so a joiner's only message
+			// outside the replay is the priority connected message.
+			cfg.PresenceBatchThreshold = 0
+			cfg.PresenceInterval = time.Hour
+			ts := startServer(t, cfg)
+			if tt.history > 0 {
+				seed := ts.dial(t, user("seed"))
+				for i := range tt.history {
+					seed.send(Message{Content: strconv.Itoa(i)})
+				}
+				seed.await("the last message", isChat(strconv.Itoa(tt.history-1)))
+				seed.conn.Close()
+			}
+
+			ts.dial(t, user("alice")).await("alice's connected message", isType(typeConnected))
+			ts.dial(t, user("bob")).await("bob's connected message", isType(typeConnected))
+		})
+	}
+}
+
+func TestReplayLimiterAcquire(t *testing.T) {
+	tests := []struct {
+		name    string
+		stopped bool
+		want    error
+	}{
+		{"slot never frees", false, errServerBusy},
+		{"hub stops", true, errShuttingDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newReplayLimiter(1)
+			if err := l.acquire(nil, time.Second); err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan struct{})
+			if tt.stopped {
+				close(done)
+			}
+			if err := l.acquire(done, 10*time.Millisecond); !errors.Is(err, tt.want) {
+				t.Errorf("acquire = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// RoomAuthorizer decides whether a user may join a room. It runs in the
+// HTTP handler before the client is registered, so it may look at the
+// request, and must be safe to call from several goroutines at once.
+type RoomAuthorizer func(username, room string, r *http.Request) bool
+
+func allowAllRooms(username, room string, r *http.Request) bool {
+	return true
+}
+
+// loadRoomACL reads a static per-room allowlist.
+func loadRoomACL(path string) (map[string]map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	acl := make(map[string]map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		room, users, ok := strings.Cut(line, "=")
+		room = strings.TrimSpace(room)
+		if !ok || !validRoomName(room) {
+			return nil, fmt.Errorf("line %d: expected room=user1,user2", n)
+		}
+		if acl[room] == nil {
+			acl[room] = make(map[string]bool)
+		}
+		for _, u := range strings.Split(users, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				acl[room][u] = true
+			}
+		}
+	}
+	return acl, scanner.Err()
+}
+
+// aclAuthorizer allows anyone into rooms the ACL doesn't mention and only
+// the listed users into the rest.
+func aclAuthorizer(acl map[string]map[string]bool) RoomAuthorizer {
+	return func(username, room string, r *http.Request) bool {
+		allowed, gated := acl[room]
+		return !gated || allowed[username]
+	}
+}
@@ -0,0 +1,57 @@
+package chat
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ContentRule is a room's requirement on message content, made by
+// ParseContentRule. Rooms without one take anything, as does the zero
+// ContentRule.
+type ContentRule struct {
+	// spec is the rule as configured, shown to users who break it.
+	spec    string
+	pattern *regexp.Regexp
+	// link requires the content to contain a link.
+	link bool
+}
+
+// ParseContentRule parses a rule as -room-format takes it: "link" for a
+// link in every message, or "/regexp/" for content matching it.
+func ParseContentRule(rule string) (ContentRule, error) {
+	switch {
+	case rule == "link":
+		return ContentRule{spec: rule, link: true}, nil
+	case len(rule) > 2 && strings.HasPrefix(rule, "/") && strings.HasSuffix(rule, "/"):
+		pattern, err := regexp.Compile(rule[1 : len(rule)-1])
+		if err != nil {
+			return ContentRule{}, fmt.Errorf("invalid pattern: %v", err)
+		}
+		return ContentRule{spec: rule, pattern: pattern}, nil
+	}
+	return ContentRule{}, fmt.Errorf("want link or /regexp/, got %q", rule)
+}
+
+func (r ContentRule) allows(content string) bool {
+	switch {
+	case r.link:
+		return linkPattern.MatchString(content)
+	case r.pattern != nil:
+		return r.pattern.MatchString(content)
+	}
+	return true
+}
+
+// checkRoomFormat is why a message doesn't meet its room's content rule,
+// if it doesn't. It must only be called from Run().
+func (h *Hub) checkRoomFormat(message Message) error {
+	rule, ok := h.roomFormats[message.Room]
+	if !ok || rule.allows(message.Content) {
+		return nil
+	}
+	if rule.link {
+		return wrapf(errRoomFormat, "#%s only takes messages with a link in them", message.Room)
+	}
+	return wrapf(errRoomFormat, "messages in #%s must match %s", message.Room, rule.spec)
+}
@@ -0,0 +1,61 @@
+package chat
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckRoomFormat(t *testing.T) {
+	rules := map[string]string{"links": "link", "tickets": `/^[A-Z]+-[0-9]+/`}
+	tests := []struct {
+		room    string
+		content string
+		wantErr bool
+	}{
+		{"links", "see https://example.com/docs", false},
+		{"links", "see example dot com", true},
+		{"tickets", "CHAT-42 is fixed", false},
+		{"tickets", "fixed CHAT-42", true},
+		{"general", "anything goes", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.room+"/"+tt.content, func(t *testing.T) {
+			cfg := DefaultConfig()
+			for room, spec := range rules {
+				rule, err := ParseContentRule(spec)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cfg.RoomFormats[room] = rule
+			}
+			err := NewHub(cfg).checkRoomFormat(Message{Room: tt.room, Content: tt.content})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkRoomFormat = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errRoomFormat) {
+				t.Errorf("error %v isn't errRoomFormat", err)
+			}
+		})
+	}
+}
+
+func TestParseContentRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		wantErr bool
+	}{
+		{"link", false},
+		{"/^ok/", false},
+		{"/(/", true},
+		{"//", true},
+		{"links", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			if _, err := ParseContentRule(tt.rule); (err != nil) != tt.wantErr {
+				t.Errorf("ParseContentRule(%q) = %v, want error %v", tt.rule, err, tt.wantErr)
+			}
+		})
+	}
+}
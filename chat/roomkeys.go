@@ -0,0 +1,265 @@
+package chat
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// encryptedPrefix starts sealed content. Whether content is sealed is
+// recorded out of band, in Message.Sealed, so plaintext that happens to start
+// with the prefix is still read as plaintext; records sealed before Sealed
+// existed only have the prefix to go on.
+const encryptedPrefix = "enc:v1:"
+
+var (
+	errUndecryptable = errors.New("stored message can't be decrypted")
+	errNoKey         = errors.New("stored message is encrypted but no key is configured for it; check -db-key and -room-keys")
+)
+
+// newAEAD makes an AES-GCM cipher from a 16, 24 or 32 byte key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadDBKey decodes -db-key. The key itself never appears in an error.
+func loadDBKey(encoded string) (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("not valid base64")
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, errors.New("must be 16, 24 or 32 bytes")
+	}
+	return aead, nil
+}
+
+// loadRoomKeys reads the room key file. Keys are never logged; errors only
+// mention the room and line.
+func loadRoomKeys(path string) (map[string]cipher.AEAD, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := make(map[string]cipher.AEAD)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		room, encoded, ok := strings.Cut(line, "=")
+		if !ok || !validRoomName(room) {
+			return nil, fmt.Errorf("line %d: expected room=base64key", n)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: key for #%s is not valid base64", n, room)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: key for #%s must be 16, 24 or 32 bytes", n, room)
+		}
+		keys[room] = aead
+	}
+	return keys, scanner.Err()
+}
+
+// encryptedStore encrypts message content at rest: with the room's own key
+// if it has one, otherwise with the -db-key, otherwise not at all. The room
+// and message ID are bound in as associated data, so ciphertext can't be
+// moved between messages.
+type encryptedStore struct {
+	MessageStore
+	keys  map[string]cipher.AEAD
+	dbKey cipher.AEAD
+}
+
+// key is the cipher for room, or nil if its messages are stored as they are.
+func (s *encryptedStore) key(room string) cipher.AEAD {
+	if aead, ok := s.keys[room]; ok {
+		return aead
+	}
+	return s.dbKey
+}
+
+func (s *encryptedStore) Append(room string, m Message) error {
+	if aead := s.key(room); aead != nil {
+		content, err := sealContent(aead, room, m)
+		if err != nil {
+			return err
+		}
+		m.Content = content
+		m.Sealed = true
+	}
+	return s.MessageStore.Append(room, m)
+}
+
+func (s *encryptedStore) Recent(room string) ([]Message, error) {
+	messages, err := s.MessageStore.Recent(room)
+	if err != nil {
+		return nil, err
+	}
+	return s.openAll(room, messages), nil
+}
+
+// Before passes through to the wrapped store, paging through what Recent
+// returns if it keeps no more than that.
+func (s *encryptedStore) Before(room, id string, n int) ([]Message, error) {
+	p, ok := s.MessageStore.(historyPager)
+	if !ok {
+		messages, err := s.Recent(room)
+		if err != nil {
+			return nil, err
+		}
+		return pageBefore(messages, id, n)
+	}
+	messages, err := p.Before(room, id, n)
+	if err != nil {
+		return nil, err
+	}
+	return s.openAll(room, messages), nil
+}
+
+// openAll decrypts messages stored for room, skipping any that fail.
+func (s *encryptedStore) openAll(room string, messages []Message) []Message {
+	aead := s.key(room)
+	out := messages[:0]
+	for _, m := range messages {
+		content, err := openContent(aead, room, m)
+		if err != nil {
+			log.Printf("Skipping message %s in #%s: %v", m.ID, room, err)
+			continue
+		}
+		m.Content = content
+		m.Sealed = false
+		out = append(out, m)
+	}
+	return out
+}
+
+// sealContent encrypts a message's content for room, bound to its ID.
+func sealContent(aead cipher.AEAD, room string, m Message) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(m.Content), []byte(room+"/"+m.ID))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openContent reverses sealContent. A nil aead can only read content that
+// was never encrypted.
+func openContent(aead cipher.AEAD, room string, m Message) (string, error) {
+	encoded, ok := strings.CutPrefix(m.Content, encryptedPrefix)
+	if !ok && m.Sealed {
+		return "", errUndecryptable
+	}
+	if !ok {
+		// Stored before the room had a key.
+		return m.Content, nil
+	}
+	if !m.Sealed {
+		// Plaintext that starts with the prefix, unless it was sealed
+		// before Sealed was recorded, in which case it opens.
+		if aead == nil {
+			return m.Content, nil
+		}
+		if plain, err := openSealed(aead, room, m.ID, encoded); err == nil {
+			return plain, nil
+		}
+		return m.Content, nil
+	}
+	if aead == nil {
+		return "", errNoKey
+	}
+	return openSealed(aead, room, m.ID, encoded)
+}
+
+// openSealed decrypts what sealContent encoded after the prefix.
+func openSealed(aead cipher.AEAD, room, id, encoded string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errUndecryptable
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, []byte(room+"/"+id))
+	if err != nil {
+		return "", errUndecryptable
+	}
+	return string(plain), nil
+}
+
+// Rooms passes through to the wrapped store, if it lists rooms.
+func (s *encryptedStore) Rooms() ([]string, error) {
+	if l, ok := s.MessageStore.(roomLister); ok {
+		return l.Rooms()
+	}
+	return nil, errors.New("store can't list rooms")
+}
+
+// Forget passes through to the wrapped store, if it forgets rooms.
+func (s *encryptedStore) Forget(room string) {
+	if f, ok := s.MessageStore.(interface{ Forget(room string) }); ok {
+		f.Forget(room)
+	}
+}
+
+// encryptedBroker does for messages relayed between instances what
+// encryptedStore does at rest: content in a room with a key crosses the
+// broker sealed, and every instance needs the same key file to read it.
+type encryptedBroker struct {
+	Broker
+	keys map[string]cipher.AEAD
+}
+
+// encryptBroker wraps b if any room has a key.
+func encryptBroker(b Broker, keys map[string]cipher.AEAD) Broker {
+	if len(keys) == 0 {
+		return b
+	}
+	return &encryptedBroker{Broker: b, keys: keys}
+}
+
+func (b *encryptedBroker) Publish(room string, m Message) error {
+	if aead, ok := b.keys[room]; ok {
+		content, err := sealContent(aead, room, m)
+		if err != nil {
+			return err
+		}
+		m.Content = content
+		m.Sealed = true
+	}
+	return b.Broker.Publish(room, m)
+}
+
+// Subscribe drops relayed messages that can't be decrypted rather than show
+// ciphertext; plaintext from an instance without the key is passed on.
+func (b *encryptedBroker) Subscribe(fn func(room string, m Message)) error {
+	return b.Broker.Subscribe(func(room string, m Message) {
+		if aead, ok := b.keys[room]; ok {
+			content, err := openContent(aead, room, m)
+			if err != nil {
+				log.Printf("Dropping relayed message %s in #%s: %v", m.ID, room, err)
+				return
+			}
+			m.Content = content
+			m.Sealed = false
+		}
+		fn(room, m)
+	})
+}
@@ -0,0 +1,204 @@
+package chat
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loopBroker hands every published message straight to its subscriber, and
+// keeps what went over the "wire".
+type loopBroker struct {
+	fn   func(room string, m Message)
+	sent []Message
+}
+
+func (b *loopBroker) Publish(room string, m Message) error {
+	b.sent = append(b.sent, m)
+	b.fn(room, m)
+	return nil
+}
+
+func (b *loopBroker) Subscribe(fn func(room string, m Message)) error {
+	b.fn = fn
+	return nil
+}
+
+func (b *loopBroker) Close() error { return nil }
+
+func testAEAD(t *testing.T, key string) cipher.AEAD {
+	t.Helper()
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead
+}
+
+func TestEncryptedBroker(t *testing.T) {
+	tests := []struct {
+		name       string
+		room       string
+		senderKey  string // "" publishes without encryption
+		wantSealed bool
+		want       string // "" means the message is dropped
+	}{
+		{"room with a key", "private", "0123456789abcdef", true, "secret"},
+		{"room without a key", "general", "0123456789abcdef", false, "secret"},
+		{"sender without the key file", "private", "", false, "secret"},
+		{"sender with another key", "private", "fedcba9876543210", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiverKeys := map[string]cipher.AEAD{"private": testAEAD(t, "0123456789abcdef")}
+			wire := &loopBroker{}
+			var got []Message
+			receiver := encryptBroker(wire, receiverKeys)
+			if err := receiver.Subscribe(func(room string, m Message) { got = append(got, m) }); err != nil {
+				t.Fatal(err)
+			}
+			sender := Broker(wire)
+			if tt.senderKey != "" {
+				sender = encryptBroker(wire, map[string]cipher.AEAD{"private": testAEAD(t, tt.senderKey)})
+			}
+
+			if err := sender.Publish(tt.room, Message{ID: "m1", Room: tt.room, Content: "secret"}); err != nil {
+				t.Fatal(err)
+			}
+			if sealed := strings.HasPrefix(wire.sent[0].Content, encryptedPrefix); sealed != tt.wantSealed {
+				t.Errorf("content on the wire %q, want sealed %v", wire.sent[0].Content, tt.wantSealed)
+			}
+			checkRead(t, "subscriber", got, tt.want)
+		})
+	}
+}
+
+func TestEncryptedStoreKeys(t *testing.T) {
+	roomKey, dbKey := testAEAD(t, "0123456789abcdef"), testAEAD(t, "fedcba9876543210")
+	keyed := map[string]cipher.AEAD{"private": roomKey}
+	tests := []struct {
+		name        string
+		write, read *encryptedStore
+		room        string
+		wantSealed  bool
+		// What reading back the room's message and bob's direct
+		// message gives; "" means it is skipped.
+		want, wantDM string
+	}{
+		{"room key", &encryptedStore{keys: keyed, dbKey: dbKey}, nil, "private", true, "secret", "psst"},
+		{"db key", &encryptedStore{keys: keyed, dbKey: dbKey}, nil, defaultRoom, true, "secret", "psst"},
+		{"no keys", &encryptedStore{}, nil, defaultRoom, false, "secret", "psst"},
+		{"db key added later", &encryptedStore{}, &encryptedStore{dbKey: dbKey}, defaultRoom, false, "secret", "psst"},
+		{"db key taken away", &encryptedStore{dbKey: dbKey}, &encryptedStore{}, defaultRoom, true, "", ""},
+		{"room key taken away", &encryptedStore{keys: keyed}, &encryptedStore{dbKey: dbKey}, "private", true, "", "psst"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			mem := newMemoryStore(cfg.historySizes(), cfg.inboxLimits())
+			write, read := tt.write, tt.read
+			if read == nil {
+				read = write
+			}
+			write.MessageStore, read.MessageStore = mem, mem
+
+			if err := write.Append(tt.room, Message{ID: "m1", Room: tt.room, Content: "secret"}); err != nil {
+				t.Fatal(err)
+			}
+			raw, _ := mem.Recent(tt.room)
+			if sealed := strings.HasPrefix(raw[0].Content, encryptedPrefix); sealed != tt.wantSealed {
+				t.Errorf("stored content %q, want sealed %v", raw[0].Content, tt.wantSealed)
+			}
+			got, err := read.Recent(tt.room)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRead(t, "history", got, tt.want)
+
+			mem.TakeInbox("bob") // Inboxes are only kept for users seen before.
+			if err := write.AddInbox("bob", Message{ID: "d1", Content: "psst", Timestamp: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+			if got, err = read.TakeInbox("bob"); err != nil {
+				t.Fatal(err)
+			}
+			checkRead(t, "inbox", got, tt.wantDM)
+		})
+	}
+}
+
+func checkRead(t *testing.T, what string, got []Message, want string) {
+	t.Helper()
+	switch {
+	case want == "" && len(got) != 0:
+		t.Errorf("%s read %q, want it skipped", what, got[0].Content)
+	case want != "" && (len(got) != 1 || got[0].Content != want):
+		t.Errorf("%s read %v, want %q", what, got, want)
+	}
+}
+
+// TestPlaintextWithSealedPrefix checks that a user's message that happens
+// to start with encryptedPrefix reads back as written, keyed or not.
+func TestPlaintextWithSealedPrefix(t *testing.T) {
+	const content = encryptedPrefix + "not really"
+	dbKey := testAEAD(t, "fedcba9876543210")
+	tests := []struct {
+		name        string
+		write, read *encryptedStore
+	}{
+		{"no keys", &encryptedStore{}, nil},
+		{"db key", &encryptedStore{dbKey: dbKey}, nil},
+		{"db key added later", &encryptedStore{}, &encryptedStore{dbKey: dbKey}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			mem := newMemoryStore(cfg.historySizes(), cfg.inboxLimits())
+			write, read := tt.write, tt.read
+			if read == nil {
+				read = write
+			}
+			write.MessageStore, read.MessageStore = mem, mem
+
+			if err := write.Append(defaultRoom, Message{ID: "m1", Room: defaultRoom, Content: content}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := read.Recent(defaultRoom)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkRead(t, "history", got, content)
+			if len(got) == 1 && got[0].Sealed {
+				t.Error("history read back still marked sealed")
+			}
+
+			mem.TakeInbox("bob") // Inboxes are only kept for users seen before.
+			if err := write.AddInbox("bob", Message{ID: "d1", Content: content, Timestamp: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+			if got, err = read.TakeInbox("bob"); err != nil {
+				t.Fatal(err)
+			}
+			checkRead(t, "inbox", got, content)
+		})
+	}
+
+	t.Run("relayed", func(t *testing.T) {
+		wire := &loopBroker{}
+		var got []Message
+		receiver := encryptBroker(wire, map[string]cipher.AEAD{"private": testAEAD(t, "0123456789abcdef")})
+		if err := receiver.Subscribe(func(room string, m Message) { got = append(got, m) }); err != nil {
+			t.Fatal(err)
+		}
+		if err := wire.Publish("private", Message{ID: "m1", Room: "private", Content: content}); err != nil {
+			t.Fatal(err)
+		}
+		checkRead(t, "subscriber", got, content)
+	})
+}
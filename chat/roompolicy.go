@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	roomPolicyCreate   = "create"
+	roomPolicyReject   = "reject"
+	roomPolicyRedirect = "redirect"
+)
+
+// resolveRoom applies the unknown-room policy to a requested room. It
+// returns the room to use and whether the client was sent elsewhere, or
+// an error wrapping errRoomNotFound. Under the create policy, which an
+// empty UnknownRoom also means, any valid name exists.
+func (h *Hub) resolveRoom(name string) (string, bool, error) {
+	policy := h.cfg.UnknownRoom
+	if policy == "" || policy == roomPolicyCreate || name == defaultRoom || h.cfg.Rooms[name] {
+		return name, false, nil
+	}
+	if policy == roomPolicyRedirect {
+		return defaultRoom, true, nil
+	}
+	return "", false, fmt.Errorf("%w: #%s", errRoomNotFound, name)
+}
+
+// rejectJoin tells a freshly upgraded connection why it can't join its room
+// and hangs up.
+func rejectJoin(conn *websocket.Conn, err error) {
+	conn.WriteMessage(websocket.CloseMessage, closeMessage(err))
+	conn.Close()
+}
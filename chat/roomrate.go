@@ -0,0 +1,40 @@
+package chat
+
+import "time"
+
+// roomLimiter is a token bucket shared by everyone posting in a room, so a
+// flash crowd can't flood the fan-out however the posts are spread across
+// senders. Slow mode, by contrast, limits each sender.
+type roomLimiter struct {
+	tokens  float64
+	updated time.Time
+}
+
+// allowRoomMessage takes a token from the room's bucket, reporting false if
+// the room is over its rate. It must only be called from Run().
+func (h *Hub) allowRoomMessage(r *room) bool {
+	rate := h.roomRate
+	if n, ok := h.roomRates[r.name]; ok {
+		rate = n
+	}
+	if rate <= 0 {
+		return true
+	}
+	burst := max(rate, 1)
+	now := time.Now()
+	if r.limiter.updated.IsZero() {
+		r.limiter.tokens = burst
+	} else {
+		r.limiter.tokens = min(burst, r.limiter.tokens+now.Sub(r.limiter.updated).Seconds()*rate)
+	}
+	r.limiter.updated = now
+	if r.limiter.tokens < 1 {
+		return false
+	}
+	r.limiter.tokens--
+	return true
+}
+
+func roomBusyError(room string) error {
+	return wrapf(errRateLimited, "#%s is busy right now, try again in a moment", room)
+}
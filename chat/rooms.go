@@ -0,0 +1,121 @@
+package chat
+
+import (
+	"log"
+	"regexp"
+	"time"
+)
+
+var roomNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+func validRoomName(name string) bool {
+	return roomNamePattern.MatchString(name)
+}
+
+// room is the hub-side state of one chat room. Like the rest of the hub it is
+// only touched from Run().
+type room struct {
+	name          string
+	clients       map[*Client]bool
+	presenceDirty bool
+	lastActive    time.Time
+	// lastPost is when each user last posted, for slow mode.
+	lastPost map[string]time.Time
+	limiter  roomLimiter
+	// owner is who opened the room by joining it first. The default room
+	// and -rooms exist up front and have no owner.
+	owner string
+}
+
+// touch marks a join, leave or message in the room.
+func (r *room) touch() {
+	r.lastActive = time.Now()
+}
+
+// permanentRoom reports whether a room is the default room or one of
+// -rooms, which exist whether or not anyone is in them.
+func (h *Hub) permanentRoom(name string) bool {
+	return name == defaultRoom || h.cfg.Rooms[name]
+}
+
+// room returns the named room, creating it on first use.
+func (h *Hub) room(name string) *room {
+	r, ok := h.rooms[name]
+	if !ok {
+		r = &room{
+			name:       name,
+			clients:    make(map[*Client]bool),
+			lastActive: time.Now(),
+			lastPost:   make(map[string]time.Time),
+		}
+		h.rooms[name] = r
+	}
+	return r
+}
+
+// makeRoomFor reports whether a client may join the named room, evicting the
+// least recently active empty room if the hub is at -max-rooms. Rooms with
+// members and permanent rooms are never evicted, so when none is left to
+// evict the join fails.
+func (h *Hub) makeRoomFor(name string) bool {
+	if _, ok := h.rooms[name]; ok || h.maxRooms <= 0 || len(h.rooms) < h.maxRooms {
+		return true
+	}
+
+	var oldest *room
+	for _, r := range h.rooms {
+		if len(r.clients) == 0 && !h.permanentRoom(r.name) && (oldest == nil || r.lastActive.Before(oldest.lastActive)) {
+			oldest = r
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	h.evictRoom(oldest, "room limit reached")
+	return true
+}
+
+// evictIdleRooms drops rooms that have had no members and no messages for the
+// idle timeout. Permanent rooms stay however quiet they are.
+func (h *Hub) evictIdleRooms(now time.Time) {
+	if h.roomIdleTimeout <= 0 {
+		return
+	}
+	for _, r := range h.rooms {
+		if h.permanentRoom(r.name) {
+			continue
+		}
+		// With archiving on, a room is only forgotten once it has been
+		// archived, so it can't skip the archive by going idle first.
+		if h.archiveAfter > 0 && h.archived[r.name].IsZero() {
+			continue
+		}
+		if len(r.clients) == 0 && now.Sub(r.lastActive) > h.roomIdleTimeout {
+			h.evictRoom(r, "idle")
+		}
+	}
+}
+
+func (h *Hub) evictRoom(r *room, reason string) {
+	delete(h.rooms, r.name)
+	if !h.archived[r.name].IsZero() {
+		// Archived rooms keep their history and pins, up to
+		// -max-archived-rooms of them.
+		log.Printf("Evicted archived room #%s from memory (%s). Rooms: %d", r.name, reason, len(h.rooms))
+		h.pruneArchived()
+		return
+	}
+	h.forgetRoom(r.name)
+	log.Printf("Evicted room #%s (%s). Rooms: %d", r.name, reason, len(h.rooms))
+}
+
+// forgetRoom drops a room's pins and stored history. It must only be called
+// from Run().
+func (h *Hub) forgetRoom(name string) {
+	delete(h.pins, name)
+	h.storeLater("history of #"+name, func(s MessageStore) {
+		if f, ok := s.(interface{ Forget(room string) }); ok {
+			f.Forget(name)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package chat
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRoomEviction(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxRooms int
+		idle     bool   // whether the idle sweep runs
+		join     string // room a client then asks for
+		joined   bool
+		want     []string
+	}{
+		{"idle sweep keeps permanent rooms", 0, true, "", false, []string{"general", "lobby"}},
+		{"cap evicts a dynamic room", 3, false, "new", true, []string{"general", "lobby", "new"}},
+		{"cap never evicts permanent rooms", 2, false, "new", false, []string{"general", "lobby"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Rooms = map[string]bool{"lobby": true}
+			cfg.RoomIdleTimeout = time.Minute
+			cfg.MaxRooms = tt.maxRooms
+			h := NewHub(cfg)
+			long := time.Now().Add(-time.Hour)
+			for _, name := range []string{defaultRoom, "lobby", "old"} {
+				h.room(name).lastActive = long
+			}
+			if tt.maxRooms > 0 && tt.maxRooms < len(h.rooms) {
+				// Start at the cap with only permanent rooms.
+				delete(h.rooms, "old")
+			}
+
+			if tt.idle {
+				h.evictIdleRooms(time.Now())
+			}
+			if tt.join != "" {
+				if got := h.makeRoomFor(tt.join); got != tt.joined {
+					t.Fatalf("makeRoomFor(%q) = %v, want %v", tt.join, got, tt.joined)
+				}
+				if tt.joined {
+					h.room(tt.join)
+				}
+			}
+			if got := slices.Sorted(maps.Keys(h.rooms)); !slices.Equal(got, tt.want) {
+				t.Errorf("rooms = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package chat
+
+import (
+	"context"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Server is a chat server: the hub, its storage and brokers, and the HTTP
+// routes in front of them.
+type Server struct {
+	cfg   Config
+	hub   *Hub
+	mux   *http.ServeMux
+	auth  *tokenAuth
+	audit *auditLog
+	// ready stays false until storage and the broker are connected and the
+	// HTTP server is about to accept connections.
+	ready atomic.Bool
+	// ctx ends when shutdown starts, for long-running work like event
+	// streams and the unfurler. WebSocket connections get connCtx so they
+	// can be closed cleanly afterwards rather than the moment it starts.
+	ctx, connCtx        context.Context
+	cancel, cancelConns context.CancelFunc
+	// closers are released once the server has stopped.
+	closers []io.Closer
+}
+
+// NewServer connects storage and brokers and loads the key and ACL files
+// cfg names. Run starts serving.
+func NewServer(cfg Config) (*Server, error) {
+	s := &Server{cfg: cfg, mux: http.NewServeMux()}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.connCtx, s.cancelConns = context.WithCancel(context.Background())
+
+	auth, err := loadTokenAuth(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("JWT config: %w", err)
+	}
+	if auth != nil && cfg.UsernameHeader != "" {
+		return nil, errors.New("use either JWT auth or -username-header, not both")
+	}
+	s.auth = auth
+
+	s.audit, err = openAuditLog(cfg.AuditLog)
+	if err != nil {
+		return nil, fmt.Errorf("audit log: %w", err)
+	}
+
+	hub := NewHub(cfg)
+	s.hub = hub
+	memory := hub.store
+	// Room keys are loaded first: the broker needs them as well as the
+	// store.
+	var roomKeys map[string]cipher.AEAD
+	if cfg.RoomKeysFile != "" {
+		if roomKeys, err = loadRoomKeys(cfg.RoomKeysFile); err != nil {
+			return nil, fmt.Errorf("room keys: %w", err)
+		}
+	}
+	var dbKey cipher.AEAD
+	if cfg.DBKey != "" {
+		if dbKey, err = loadDBKey(cfg.DBKey); err != nil {
+			return nil, fmt.Errorf("-db-key: %w", err)
+		}
+	}
+	if cfg.RedisURL != "" {
+		rs, err := newRedisStore(cfg.RedisURL, cfg.historySizes(), cfg.inboxLimits())
+		switch {
+		case err != nil && !cfg.StorageOptional:
+			return nil, fmt.Errorf("redis: %w", err)
+		case err != nil:
+			log.Printf("WARNING: Redis unreachable (%v); history is in memory only and lost on restart, and messages aren't shared with other instances", err)
+		default:
+			hub.store = newResilientStore(rs, cfg.StorageBuffer)
+			log.Println("Using Redis for history")
+			if cfg.NATSURL == "" {
+				broker := newRedisBroker(rs.client)
+				if err := hub.useBroker(encryptBroker(broker, roomKeys)); err != nil {
+					return nil, fmt.Errorf("redis subscribe: %w", err)
+				}
+				s.closers = append(s.closers, broker)
+				log.Println("Using Redis for cross-instance broadcast")
+			}
+		}
+	}
+	if cfg.NATSURL != "" {
+		broker, err := newNATSBroker(cfg.NATSURL)
+		if err != nil {
+			return nil, fmt.Errorf("NATS: %w", err)
+		}
+		if err := hub.useBroker(encryptBroker(broker, roomKeys)); err != nil {
+			return nil, fmt.Errorf("NATS subscribe: %w", err)
+		}
+		s.closers = append(s.closers, broker)
+		log.Println("Using NATS for cross-instance broadcast")
+	}
+	if cfg.RoomACLFile != "" {
+		if cfg.RoomAuthorizer != nil {
+			return nil, errors.New("use either a room ACL file or a RoomAuthorizer, not both")
+		}
+		// Without JWT auth or a proxy's header, clients name themselves,
+		// and anyone could claim a listed name.
+		if auth == nil && cfg.UsernameHeader == "" {
+			return nil, errors.New("-room-acl needs authenticated usernames: set up JWT auth or -username-header")
+		}
+		acl, err := loadRoomACL(cfg.RoomACLFile)
+		if err != nil {
+			return nil, fmt.Errorf("room ACL: %w", err)
+		}
+		hub.authorizeJoin = aclAuthorizer(acl)
+		hub.roomACL = acl
+		log.Printf("Restricting %d rooms to listed users", len(acl))
+	}
+	// A store that outlives the process is wrapped even without keys, so
+	// anything encrypted under a key since removed is reported rather than
+	// shown as ciphertext.
+	if roomKeys != nil || dbKey != nil || hub.store != memory {
+		hub.store = &encryptedStore{MessageStore: hub.store, keys: roomKeys, dbKey: dbKey}
+	}
+	if roomKeys != nil {
+		log.Printf("Encrypting history at rest and between instances for %d rooms", len(roomKeys))
+	}
+	if dbKey != nil {
+		log.Println("Encrypting stored messages with -db-key")
+	}
+	if cfg.BotKeysFile != "" {
+		keys, err := loadBotKeys(cfg.BotKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("bot keys: %w", err)
+		}
+		hub.botKeys = keys
+		log.Printf("Requiring signed posts from %d bots", len(keys))
+	}
+	if cfg.Unfurl {
+		hub.unfurler = newUnfurler(hub)
+	}
+	if err := s.routes(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ServeHTTP serves the server's routes, for embedding them in another mux.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) routes() error {
+	hub, auth, audit := s.hub, s.auth, s.audit
+	home, err := homeHandler(s.cfg.StaticDir)
+	if err != nil {
+		return fmt.Errorf("static dir: %w", err)
+	}
+	s.mux.HandleFunc("/", s.withGzip(home))
+	s.mux.HandleFunc("GET /healthz", serveHealthz)
+	s.mux.Handle("GET /metrics", promhttp.HandlerFor(hub.metrics.registry, promhttp.HandlerOpts{}))
+	s.mux.HandleFunc("GET /ready", func(w http.ResponseWriter, r *http.Request) {
+		serveReady(hub, &s.ready, w, r)
+	})
+	s.mux.HandleFunc("/stats", s.withGzip(func(w http.ResponseWriter, r *http.Request) {
+		serveStats(hub, w, r)
+	}))
+	s.mux.HandleFunc("GET /events", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		serveEvents(s.ctx, hub, w, r)
+	}))
+	s.mux.HandleFunc("GET /api/messages", s.withGzip(func(w http.ResponseWriter, r *http.Request) {
+		serveHistory(hub, auth, w, r)
+	}))
+	s.mux.HandleFunc("GET /api/rooms", s.withGzip(func(w http.ResponseWriter, r *http.Request) {
+		serveRooms(hub, auth, w, r)
+	}))
+	s.mux.HandleFunc("GET /api/rooms/{room}/pins", s.withGzip(func(w http.ResponseWriter, r *http.Request) {
+		servePins(hub, auth, w, r)
+	}))
+	s.mux.HandleFunc("GET /api/capabilities", s.withGzip(func(w http.ResponseWriter, r *http.Request) {
+		serveCapabilities(hub, w, r)
+	}))
+	s.mux.HandleFunc("POST /api/messages", s.requireAdmin(s.withGzipBody(func(w http.ResponseWriter, r *http.Request) {
+		servePostMessage(hub, w, r)
+	})))
+	s.mux.HandleFunc("POST /api/messages/validate", s.requireAdmin(s.withGzipBody(func(w http.ResponseWriter, r *http.Request) {
+		serveValidateMessage(hub, w, r)
+	})))
+	s.mux.HandleFunc("POST /admin/drain", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		serveDrain(hub, audit, true, w, r)
+	}))
+	s.mux.HandleFunc("POST /admin/undrain", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		serveDrain(hub, audit, false, w, r)
+	}))
+	s.mux.HandleFunc("POST /admin/rooms/{room}/slowmode", s.requireAdmin(s.withGzipBody(func(w http.ResponseWriter, r *http.Request) {
+		serveSlowMode(hub, audit, w, r)
+	})))
+	s.mux.HandleFunc("POST /admin/rooms/{room}/archive", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		serveArchive(hub, audit, true, w, r)
+	}))
+	s.mux.HandleFunc("POST /admin/rooms/{room}/unarchive", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		serveArchive(hub, audit, false, w, r)
+	}))
+	s.mux.HandleFunc("POST /admin/rooms/{room}/hide", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		serveDiscoverable(hub, audit, false, w, r)
+	}))
+	s.mux.HandleFunc("POST /admin/rooms/{room}/show", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		serveDiscoverable(hub, audit, true, w, r)
+	}))
+	s.mux.HandleFunc("POST /admin/motd", s.requireAdmin(s.withGzipBody(func(w http.ResponseWriter, r *http.Request) {
+		serveMOTD(hub, audit, w, r)
+	})))
+	s.mux.HandleFunc("GET /connections", s.requireAdmin(s.withGzip(func(w http.ResponseWriter, r *http.Request) {
+		serveConnections(hub, w, r)
+	})))
+	s.mux.HandleFunc("GET /admin/export", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		serveExport(hub, audit, w, r)
+	}))
+	s.mux.HandleFunc("GET /admin/audit", s.requireAdmin(s.withGzip(func(w http.ResponseWriter, r *http.Request) {
+		serveAudit(audit, w, r)
+	})))
+	s.mux.HandleFunc("GET /debug/chat", s.requireAdmin(s.withGzip(func(w http.ResponseWriter, r *http.Request) {
+		serveDebug(hub, w, r)
+	})))
+	s.mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		serveWS(s.connCtx, hub, auth, w, r)
+	})
+	return nil
+}
+
+// Run serves until ctx is done, then shuts down gracefully: HTTP first, then
+// every WebSocket connection gets its close frame, then the hub stops.
+func (s *Server) Run(ctx context.Context) error {
+	defer func() {
+		for _, c := range s.closers {
+			c.Close()
+		}
+		s.audit.close()
+	}()
+	go s.hub.Run()
+	if s.hub.unfurler != nil {
+		go s.hub.unfurler.run(s.ctx, s.cfg.UnfurlRate)
+		log.Println("Unfurling links in room messages")
+	}
+
+	// ReadHeaderTimeout covers the part of the handshake before serveWS
+	// runs, so a client trickling headers can't hold a handler open.
+	srv := &http.Server{
+		Addr:              s.cfg.Addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: s.cfg.HandshakeTimeout,
+	}
+	// Bind before reporting ready, so /ready never says yes while the
+	// port is still closed or taken.
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		s.cancel()
+		s.stopHub()
+		return err
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			serveErr <- err
+		}
+	}()
+
+	s.ready.Store(true)
+	fmt.Printf("Chat server running on %s\n", ln.Addr())
+	select {
+	case <-ctx.Done():
+	case err = <-serveErr:
+	}
+	log.Println("Shutting down")
+	s.ready.Store(false)
+	s.cancel()
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP shutdown error: %v", err)
+	}
+
+	s.stopHub()
+	return err
+}
+
+// stopHub closes the WebSocket connections, which outlive HTTP Shutdown:
+// each gets a chance at its close frame, then every readPump is stopped
+// before the hub goes away.
+func (s *Server) stopHub() {
+	closed, forced := closeGracefully(s.hub, s.cfg.ShutdownGrace)
+	s.cancelConns()
+	s.hub.Stop()
+	s.hub.logShutdown(closed, forced)
+}
@@ -0,0 +1,234 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testTimeout bounds every wait in the end-to-end tests.
+const testTimeout = 5 * time.Second
+
+// testServer is a Server behind an httptest.Server, with its hub running.
+type testServer struct {
+	*httptest.Server
+	srv *Server
+}
+
+// startServer starts a Server with cfg on a local port and stops it when the
+// test ends.
+func startServer(t testing.TB, cfg Config) *testServer {
+	t.Helper()
+	srv, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.hub.Run()
+	ts := &testServer{Server: httptest.NewServer(srv), srv: srv}
+	t.Cleanup(func() {
+		srv.cancelConns()
+		srv.hub.Stop()
+		srv.cancel()
+		ts.Close()
+	})
+	return ts
+}
+
+// testClient is a real WebSocket client connected to a testServer.
+type testClient struct {
+	t    testing.TB
+	conn *websocket.Conn
+}
+
+// dial connects to the server's /ws with the given query parameters.
+func (ts *testServer) dial(t testing.TB, query url.Values) *testClient {
+	t.Helper()
+	return ts.dialHeader(t, query, nil)
+}
+
+// dialHeader is dial with request headers, say for a proxy's username
+// header or the admin token.
+func (ts *testServer) dialHeader(t testing.TB, query url.Values, header http.Header) *testClient {
+	t.Helper()
+	conn, _, err := ts.tryDial(query, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn}
+}
+
+// tryDial is dial for tests that expect it may fail.
+func (ts *testServer) tryDial(query url.Values, header http.Header) (*websocket.Conn, *http.Response, error) {
+	u := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?" + query.Encode()
+	return websocket.DefaultDialer.Dial(u, header)
+}
+
+func (c *testClient) send(m Message) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(m); err != nil {
+		c.t.Fatalf("send: %v", err)
+	}
+}
+
+// await reads until a message matches, failing the test if none does
+// within testTimeout.
+func (c *testClient) await(what string, match func(Message) bool) Message {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(testTimeout))
+	for {
+		var m Message
+		if err := c.conn.ReadJSON(&m); err != nil {
+			c.t.Fatalf("waiting for %s: %v", what, err)
+		}
+		if match(m) {
+			return m
+		}
+	}
+}
+
+func isType(typ string) func(Message) bool {
+	return func(m Message) bool { return m.Type == typ }
+}
+
+func isChat(content string) func(Message) bool {
+	return func(m Message) bool { return m.Type == typeChat && m.Content == content }
+}
+
+func isPresence(online ...string) func(Message) bool {
+	return func(m Message) bool { return m.Type == typePresence && slices.Equal(m.Online, online) }
+}
+
+func user(name string) url.Values {
+	return url.Values{"username": {name}}
+}
+
+func TestServerClientsSeeEachOthersMessages(t *testing.T) {
+	ts := startServer(t, DefaultConfig())
+	alice := ts.dial(t, user("alice"))
+	alice.await("alice's presence", isPresence("alice"))
+	bob := ts.dial(t, user("bob"))
+	alice.await("bob joining", isPresence("alice", "bob"))
+
+	alice.send(Message{Content: "hi bob"})
+	got := bob.await("alice's message", isChat("hi bob"))
+	if got.Username != "alice" || got.Room != defaultRoom {
+		t.Errorf("bob got %s in #%s, want alice in #%s", got.Username, got.Room, defaultRoom)
+	}
+	bob.send(Message{Content: "hi alice"})
+	alice.await("bob's message", isChat("hi alice"))
+}
+
+func TestServerJoinAndLeaveNotices(t *testing.T) {
+	ts := startServer(t, DefaultConfig())
+	alice := ts.dial(t, user("alice"))
+	alice.await("alice's presence", isPresence("alice"))
+
+	bob := ts.dial(t, user("bob"))
+	alice.await("bob joining", isPresence("alice", "bob"))
+	bob.conn.Close()
+	alice.await("bob leaving", isPresence("alice"))
+}
+
+func TestServerRoomRateLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RoomRate = 1
+	ts := startServer(t, cfg)
+	alice := ts.dial(t, user("alice"))
+	alice.await("alice's presence", isPresence("alice"))
+
+	for range 3 {
+		alice.send(Message{Content: "spam"})
+	}
+	alice.await("the first message", isChat("spam"))
+	got := alice.await("a rate limit error", isType(typeError))
+	if !strings.Contains(got.Content, "busy") {
+		t.Errorf("error = %q, want the room to be busy", got.Content)
+	}
+}
+
+// isTimeout reports whether a read gave up at its deadline, as opposed to
+// the server hanging up.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// TestServerReadyOnlyOnceListening checks that Run never reports ready for
+// an address it couldn't bind.
+func TestServerReadyOnlyOnceListening(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	free.Close()
+
+	tests := []struct {
+		name    string
+		addr    string
+		wantErr bool
+	}{
+		{"free port", free.Addr().String(), false},
+		{"port in use", taken.Addr().String(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Addr = tt.addr
+			srv, err := NewServer(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- srv.Run(ctx) }()
+
+			var ready bool
+			deadline := time.Now().Add(testTimeout)
+			for !ready && time.Now().Before(deadline) {
+				select {
+				case err := <-done:
+					if (err != nil) != tt.wantErr {
+						t.Fatalf("Run = %v, want error %v", err, tt.wantErr)
+					}
+					if srv.ready.Load() {
+						t.Error("ready after Run returned")
+					}
+					return
+				case <-time.After(5 * time.Millisecond):
+					ready = srv.ready.Load()
+				}
+			}
+			if tt.wantErr {
+				t.Fatalf("ready = %v and Run still going, want it to fail", ready)
+			}
+			if !ready {
+				t.Fatal("never became ready")
+			}
+			conn, err := net.Dial("tcp", tt.addr)
+			if err != nil {
+				t.Fatalf("ready but not listening: %v", err)
+			}
+			conn.Close()
+			cancel()
+			if err := <-done; err != nil {
+				t.Errorf("Run = %v after shutdown", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package chat
+
+import (
+	"log"
+	"maps"
+	"slices"
+)
+
+const (
+	sessionsMultiple = "multiple"
+	sessionsTakeover = "takeover"
+)
+
+// takeOverSessions hangs up on the user's other sessions when the takeover
+// policy is on, carrying their mutes over to the new one. Spectators neither
+// replace nor get replaced. It must only be called from Run(), before client
+// is added.
+func (h *Hub) takeOverSessions(client *Client) {
+	if h.sessionPolicy != sessionsTakeover || client.spectator {
+		return
+	}
+	for _, old := range slices.Clone(h.users[client.username]) {
+		if old.spectator {
+			continue
+		}
+		log.Printf("Closing %s (%s): session replaced by %s", old.username, old.id, client.id)
+		maps.Copy(client.muted, old.muted)
+		err := wrapf(errSessionReplaced, "you've connected from somewhere else, so this session has been closed")
+		h.sendTo(old, errorMessage(err))
+		old.closeErr = err
+		h.removeClient(old)
+	}
+}
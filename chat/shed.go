@@ -0,0 +1,28 @@
+package chat
+
+import "log"
+
+func serverBusyError() error {
+	return wrapf(errServerBusy, "the server is busy right now, try again in a moment")
+}
+
+// overloaded reports whether the hub is shedding load, starting or stopping
+// as the broadcast queue crosses the thresholds. It is safe to call from any
+// goroutine.
+func (h *Hub) overloaded() bool {
+	if h.shedAt <= 0 || cap(h.broadcast) == 0 {
+		return false
+	}
+	full := float64(len(h.broadcast)) / float64(cap(h.broadcast))
+	switch {
+	case full >= h.shedAt:
+		if h.shedding.CompareAndSwap(false, true) {
+			log.Printf("Shedding load: broadcast queue %d/%d", len(h.broadcast), cap(h.broadcast))
+		}
+	case full <= h.shedResume:
+		if h.shedding.CompareAndSwap(true, false) {
+			log.Printf("Load back to normal: broadcast queue %d/%d", len(h.broadcast), cap(h.broadcast))
+		}
+	}
+	return h.shedding.Load()
+}
@@ -0,0 +1,56 @@
+package chat
+
+import (
+	"log"
+	"time"
+)
+
+// closeAll hangs up on every client the clean way: closing send lets each
+// writePump flush what is queued and send a close frame. It returns the
+// clients it closed.
+func (h *Hub) closeAll() []*Client {
+	h.draining.Store(true)
+	var closed []*Client
+	h.do(func() {
+		for client := range h.clients {
+			closed = append(closed, client)
+			h.removeClient(client)
+		}
+	})
+	return closed
+}
+
+// closeGracefully closes every connection, waiting up to grace for their
+// writePumps to finish before cutting off the rest. It reports how many
+// connections there were and how many had to be force-closed.
+func closeGracefully(hub *Hub, grace time.Duration) (closed, forced int) {
+	clients := hub.closeAll()
+	deadline := time.After(grace)
+
+	expired := false
+	for _, client := range clients {
+		if !expired {
+			select {
+			case <-client.writeDone:
+				continue
+			case <-deadline:
+				expired = true
+			}
+		}
+		select {
+		case <-client.writeDone:
+		default:
+			client.conn.Close()
+			forced++
+		}
+	}
+	return len(clients), forced
+}
+
+// logShutdown sums up the server's run in one line for the post-mortem.
+// Connections that weren't force-closed got their close frame.
+func (h *Hub) logShutdown(closed, forced int) {
+	s := h.stats.snapshot()
+	log.Printf("Shutdown: uptime=%v clients=%d close_frames=%d force_closed=%d messages=%d",
+		time.Since(h.startedAt).Round(time.Second), closed, closed-forced, forced, s.Messages)
+}
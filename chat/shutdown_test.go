@@ -0,0 +1,60 @@
+package chat
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestShutdownWithActiveClients stops a server with clients in every state
+// and checks each of them is hung up on.
+func TestShutdownWithActiveClients(t *testing.T) {
+	tests := []struct {
+		name    string
+		clients int
+		// talking clients keep posting through the shutdown.
+		talking bool
+	}{
+		{"no clients", 0, false},
+		{"idle clients", 5, false},
+		{"talking clients", 5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.RoomRate = 0
+			cfg.ShutdownGrace = time.Second
+			ts := startServer(t, cfg)
+
+			var clients []*testClient
+			for i := range tt.clients {
+				c := ts.dial(t, user(fmt.Sprintf("user-%d", i)))
+				c.await("own presence", isType(typePresence))
+				clients = append(clients, c)
+			}
+			// Start talking once everyone is in, or load shedding could
+			// turn later clients away.
+			for _, c := range clients {
+				if tt.talking {
+					go func() {
+						for c.conn.WriteJSON(Message{Content: "still here"}) == nil {
+						}
+					}()
+				}
+			}
+
+			ts.srv.stopHub()
+
+			for i, c := range clients {
+				c.conn.SetReadDeadline(time.Now().Add(testTimeout))
+				var err error
+				for err == nil {
+					_, _, err = c.conn.ReadMessage()
+				}
+				if isTimeout(err) {
+					t.Errorf("client %d wasn't hung up on", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// checkSlowMode reports how long the sender still has to wait before posting
+// in the message's room, recording the post if they don't. It must only be
+// called from Run().
+func (h *Hub) checkSlowMode(r *room, message Message) time.Duration {
+	interval := h.slowModes[r.name]
+	if interval <= 0 {
+		return 0
+	}
+	now := time.Now()
+	if last, ok := r.lastPost[message.Username]; ok {
+		if wait := interval - now.Sub(last); wait > 0 {
+			return wait
+		}
+	}
+	r.lastPost[message.Username] = now
+	return 0
+}
+
+func slowModeError(room string, wait time.Duration) error {
+	secs := int(math.Ceil(wait.Seconds()))
+	return wrapf(errRateLimited, "slow mode is on in #%s: wait %ds before posting again", room, secs)
+}
+
+// serveSlowMode sets a room's slow-mode interval, e.g. {"interval":"10s"}.
+// An interval of "0s" turns it off.
+func serveSlowMode(hub *Hub, audit *auditLog, w http.ResponseWriter, r *http.Request) {
+	room := r.PathValue("room")
+	if !validRoomName(room) {
+		http.Error(w, "invalid room name", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Interval string `json:"interval"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	interval, err := time.ParseDuration(body.Interval)
+	if err != nil || interval < 0 {
+		http.Error(w, "invalid interval", http.StatusBadRequest)
+		return
+	}
+
+	if !hub.do(func() {
+		if interval == 0 {
+			delete(hub.slowModes, room)
+		} else {
+			hub.slowModes[room] = interval
+		}
+	}) {
+		http.Error(w, "hub stopped", http.StatusServiceUnavailable)
+		return
+	}
+	audit.record(r, "slowmode", fmt.Sprintf("#%s=%s", room, interval))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,44 @@
+package chat
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// stalled reports whether a client's send buffer is nearly full.
+func (c *Client) stalled() bool {
+	return len(c.send) >= cap(c.send)*9/10
+}
+
+// dropStalledClients disconnects clients that have stopped reading. A buffer
+// that fills up eventually gets the client dropped anyway, but only once a
+// broadcast overflows it; this frees the connection sooner. It must only be
+// called from Run().
+func (h *Hub) dropStalledClients(now time.Time) {
+	if h.stallTimeout <= 0 {
+		return
+	}
+	for client := range h.clients {
+		if !client.stalled() {
+			client.stalledSince = time.Time{}
+			continue
+		}
+		if client.stalledSince.IsZero() {
+			client.stalledSince = now
+			continue
+		}
+		if now.Sub(client.stalledSince) < h.stallTimeout {
+			continue
+		}
+		log.Printf("Dropping %s (%s): not consuming messages for %v", client.username, client.id, now.Sub(client.stalledSince))
+		h.removeClient(client)
+		// writePump is most likely stuck writing to a peer that won't
+		// read, so hang up from here rather than through it.
+		go func(conn *websocket.Conn) {
+			conn.WriteControl(websocket.CloseMessage, closeMessage(errNotConsuming), time.Now().Add(time.Second))
+			conn.Close()
+		}(client.conn)
+	}
+}
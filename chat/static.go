@@ -0,0 +1,29 @@
+package chat
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// homeHandler serves the built-in page, or the files in dir if it is set.
+func homeHandler(dir string) (http.HandlerFunc, error) {
+	if dir == "" {
+		return serveHome, nil
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.html")); err != nil {
+		return nil, err
+	}
+	files := http.FileServer(http.Dir(dir))
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Keep .git, .env and the like private.
+		for _, part := range strings.Split(r.URL.Path, "/") {
+			if strings.HasPrefix(part, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		files.ServeHTTP(w, r)
+	}, nil
+}
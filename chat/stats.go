@@ -0,0 +1,83 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// hubStats holds counters updated by the hub's run loop and read by /stats.
+type hubStats struct {
+	mu        sync.Mutex
+	clients   int
+	users     int
+	messages  int
+	platforms map[string]int
+	// sendHWM counts disconnected clients by how full their send buffer
+	// ever got.
+	sendHWM map[string]int
+}
+
+type statsSnapshot struct {
+	Clients   int            `json:"clients"`
+	Users     int            `json:"users"`
+	Messages  int            `json:"messages"`
+	Platforms map[string]int `json:"platforms"`
+	SendHWM   map[string]int `json:"sendHighWater"`
+	BytesIn   int64          `json:"bytesIn"`
+	BytesOut  int64          `json:"bytesOut"`
+	Shedding  bool           `json:"shedding"`
+}
+
+func newHubStats() *hubStats {
+	return &hubStats{platforms: make(map[string]int), sendHWM: make(map[string]int)}
+}
+
+func (s *hubStats) setClients(clients, users int) {
+	s.mu.Lock()
+	s.clients = clients
+	s.users = users
+	s.mu.Unlock()
+}
+
+func (s *hubStats) recordMessage(m Message) {
+	s.mu.Lock()
+	s.messages++
+	s.platforms[m.Platform]++
+	s.mu.Unlock()
+}
+
+func (s *hubStats) recordSendHWM(bucket string) {
+	s.mu.Lock()
+	s.sendHWM[bucket]++
+	s.mu.Unlock()
+}
+
+func (s *hubStats) snapshot() statsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	platforms := make(map[string]int, len(s.platforms))
+	for k, v := range s.platforms {
+		platforms[k] = v
+	}
+	sendHWM := make(map[string]int, len(s.sendHWM))
+	for k, v := range s.sendHWM {
+		sendHWM[k] = v
+	}
+	return statsSnapshot{
+		Clients:   s.clients,
+		Users:     s.users,
+		Messages:  s.messages,
+		Platforms: platforms,
+		SendHWM:   sendHWM,
+		BytesIn:   totalBytesIn.Load(),
+		BytesOut:  totalBytesOut.Load(),
+	}
+}
+
+func serveStats(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	s := hub.stats.snapshot()
+	s.Shedding = hub.overloaded()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+// TestOnlyAcceptedMessagesAreCounted puts messages straight onto the
+// broadcast queue and checks that the message count and the message events
+// only include those that got past every check.
+func TestOnlyAcceptedMessagesAreCounted(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   func(*Config)
+		setup func(*Hub)
+		send  []Message
+		want  int
+	}{
+		{"accepted", nil, nil, []Message{{Content: "hi"}}, 1},
+		{"direct", nil, nil, []Message{{Type: typeDirect, To: "bob", Content: "hi"}}, 1},
+		{"archived room", nil, func(h *Hub) { h.archived[defaultRoom] = time.Now() }, []Message{{Content: "hi"}}, 0},
+		{"wrong format", func(cfg *Config) { cfg.RoomFormats[defaultRoom], _ = ParseContentRule("link") }, nil, []Message{{Content: "hi"}}, 0},
+		{"slow mode", func(cfg *Config) { cfg.SlowModes[defaultRoom] = time.Minute }, nil, []Message{{Content: "1"}, {Content: "2"}}, 1},
+		{"room rate", func(cfg *Config) { cfg.RoomRate = 1 }, nil, []Message{{Content: "1"}, {Content: "2"}, {Content: "3"}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			if tt.cfg != nil {
+				tt.cfg(&cfg)
+			}
+			h := NewHub(cfg)
+			go h.Run()
+			defer h.Stop()
+			if tt.setup != nil {
+				h.do(func() { tt.setup(h) })
+			}
+			events := h.events.subscribe()
+			defer h.events.unsubscribe(events)
+
+			for _, m := range tt.send {
+				if m.Type == "" {
+					m.Type, m.Room = typeChat, defaultRoom
+				}
+				m.ID, m.Username, m.Timestamp = newID(), "alice", time.Now()
+				h.broadcast <- m
+			}
+			// The queue is first in, first out, so once this direct
+			// message is counted everything before it has been handled.
+			h.broadcast <- Message{ID: "last", Type: typeDirect, Username: "zed", To: "nobody", Content: "done", Timestamp: time.Now()}
+			var published int
+			for ev := range events {
+				if ev.Type != eventMessage {
+					continue
+				}
+				published++
+				if ev.Username == "zed" {
+					break
+				}
+			}
+
+			if got := h.stats.snapshot().Messages - 1; got != tt.want {
+				t.Errorf("counted %d messages, want %d", got, tt.want)
+			}
+			if published-1 != tt.want {
+				t.Errorf("published %d message events, want %d", published-1, tt.want)
+			}
+		})
+	}
+}
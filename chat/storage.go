@@ -0,0 +1,249 @@
+package chat
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// storageBackoff is how long the hub leaves storage alone after a failure,
+// so the storage goroutine doesn't spend a timeout on every message while
+// it is down.
+const storageBackoff = 5 * time.Second
+
+var errStorageDown = errors.New("storage unreachable")
+
+// storageQueueSize bounds the store calls waiting for the storage goroutine.
+// Once it is full Run() drops writes rather than wait.
+const storageQueueSize = 4096
+
+var errStorageBusy = errors.New("storage queue full")
+
+// runStorage makes every store call, one at a time and in the order they
+// were queued, so a read queued after a write sees it. Run() only ever
+// queues, so however slow the store gets chat doesn't wait on it.
+func (h *Hub) runStorage() {
+	defer close(h.storageStopped)
+	for {
+		select {
+		case fn := <-h.storage:
+			fn()
+		case <-h.done:
+			// Writes queued before the hub stopped still go out.
+			for {
+				select {
+				case fn := <-h.storage:
+					fn()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// storeLater queues a store call without waiting for it, dropping it if the
+// queue is full. It must only be called from Run().
+func (h *Hub) storeLater(what string, fn func(s MessageStore)) {
+	select {
+	case h.storage <- func() { fn(h.store) }:
+	default:
+		log.Printf("Storage queue full, dropping %s", what)
+	}
+}
+
+// storeThen queues a store call and runs then back on Run() with its error
+// once it has been made, or straight away with errStorageBusy if the queue
+// is full. It must only be called from Run().
+func (h *Hub) storeThen(fn func(s MessageStore) error, then func(err error)) {
+	job := func() {
+		err := fn(h.store)
+		select {
+		case h.ops <- func() { then(err) }:
+		case <-h.done:
+		}
+	}
+	select {
+	case h.storage <- job:
+	default:
+		then(errStorageBusy)
+	}
+}
+
+// storeMessage queues a room message for the store, without what only live
+// delivery uses. It must only be called from Run().
+func (h *Hub) storeMessage(room string, m Message) {
+	m.received = time.Time{}
+	m.ClientMsgID = ""
+	h.storeLater("message "+m.ID, func(s MessageStore) {
+		if err := s.Append(room, m); err != nil && !errors.Is(err, errStorageDown) {
+			log.Printf("Failed to store message %s: %v", m.ID, err)
+		}
+	})
+}
+
+// readStore runs fn on the storage goroutine and waits for it, so HTTP
+// handlers can read the store. It reports false if the hub has stopped.
+func (h *Hub) readStore(fn func(s MessageStore)) bool {
+	done := make(chan struct{})
+	select {
+	case h.storage <- func() { fn(h.store); close(done) }:
+	case <-h.done:
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-h.storageStopped:
+		// Queued too late for the storage goroutine's last drain?
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// resilientStore keeps a remote store's outages from stalling the hub.
+// After a failure it fails fast for storageBackoff instead of waiting out
+// another timeout, and it holds appends made meanwhile to write them when
+// the store answers again. Like every store it is only used from the
+// storage goroutine.
+type resilientStore struct {
+	MessageStore
+	downUntil  time.Time
+	pending    []pendingAppend
+	maxPending int
+}
+
+type pendingAppend struct {
+	room string
+	m    Message
+}
+
+func newResilientStore(s MessageStore, maxPending int) *resilientStore {
+	return &resilientStore{MessageStore: s, maxPending: maxPending}
+}
+
+func (s *resilientStore) down() bool {
+	return time.Now().Before(s.downUntil)
+}
+
+// failed records an error from the store, starting a backoff if it is the
+// first since the store last worked.
+func (s *resilientStore) failed(err error) error {
+	if s.downUntil.IsZero() {
+		log.Printf("Storage unreachable, serving from memory for now: %v", err)
+	}
+	s.downUntil = time.Now().Add(storageBackoff)
+	return err
+}
+
+func (s *resilientStore) ok() {
+	if !s.downUntil.IsZero() {
+		log.Printf("Storage is back")
+		s.downUntil = time.Time{}
+	}
+}
+
+func (s *resilientStore) hold(room string, m Message) {
+	if s.maxPending <= 0 {
+		return
+	}
+	if len(s.pending) >= s.maxPending {
+		log.Printf("Storage buffer full, dropping message %s", s.pending[0].m.ID)
+		s.pending = s.pending[1:]
+	}
+	s.pending = append(s.pending, pendingAppend{room, m})
+}
+
+func (s *resilientStore) Append(room string, m Message) error {
+	if s.down() {
+		s.hold(room, m)
+		return errStorageDown
+	}
+	for len(s.pending) > 0 {
+		p := s.pending[0]
+		if err := s.MessageStore.Append(p.room, p.m); err != nil {
+			s.hold(room, m)
+			return s.failed(err)
+		}
+		s.pending = s.pending[1:]
+	}
+	if err := s.MessageStore.Append(room, m); err != nil {
+		s.hold(room, m)
+		return s.failed(err)
+	}
+	s.ok()
+	return nil
+}
+
+func (s *resilientStore) Recent(room string) ([]Message, error) {
+	if s.down() {
+		return nil, errStorageDown
+	}
+	messages, err := s.MessageStore.Recent(room)
+	if err != nil {
+		return nil, s.failed(err)
+	}
+	s.ok()
+	return messages, nil
+}
+
+// Before passes through to the wrapped store, paging through what Recent
+// returns if it keeps no more than that.
+func (s *resilientStore) Before(room, id string, n int) ([]Message, error) {
+	p, ok := s.MessageStore.(historyPager)
+	if !ok {
+		messages, err := s.Recent(room)
+		if err != nil {
+			return nil, err
+		}
+		return pageBefore(messages, id, n)
+	}
+	if s.down() {
+		return nil, errStorageDown
+	}
+	messages, err := p.Before(room, id, n)
+	if err != nil && !errors.Is(err, errNotInHistory) {
+		return nil, s.failed(err)
+	}
+	s.ok()
+	return messages, err
+}
+
+func (s *resilientStore) Remove(room, id string) error {
+	if s.down() {
+		return errStorageDown
+	}
+	if err := s.MessageStore.Remove(room, id); err != nil {
+		return s.failed(err)
+	}
+	s.ok()
+	return nil
+}
+
+// Rooms passes through to the wrapped store, if it lists rooms.
+func (s *resilientStore) Rooms() ([]string, error) {
+	l, ok := s.MessageStore.(roomLister)
+	if !ok {
+		return nil, errors.New("store can't list rooms")
+	}
+	if s.down() {
+		return nil, errStorageDown
+	}
+	rooms, err := l.Rooms()
+	if err != nil {
+		return nil, s.failed(err)
+	}
+	s.ok()
+	return rooms, nil
+}
+
+// Forget passes through to the wrapped store, if it forgets rooms.
+func (s *resilientStore) Forget(room string) {
+	if f, ok := s.MessageStore.(interface{ Forget(room string) }); ok && !s.down() {
+		f.Forget(room)
+	}
+}
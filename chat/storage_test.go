@@ -0,0 +1,189 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyStore is a memoryStore that fails every call while fail is set.
+type flakyStore struct {
+	*memoryStore
+	fail  bool
+	calls int
+}
+
+var errFlaky = errors.New("store is down")
+
+func newFlakyStore() *flakyStore {
+	return &flakyStore{memoryStore: newMemoryStore(historySizes{def: 50}, inboxLimits{})}
+}
+
+func (s *flakyStore) Append(room string, m Message) error {
+	s.calls++
+	if s.fail {
+		return errFlaky
+	}
+	return s.memoryStore.Append(room, m)
+}
+
+func (s *flakyStore) Recent(room string) ([]Message, error) {
+	s.calls++
+	if s.fail {
+		return nil, errFlaky
+	}
+	return s.memoryStore.Recent(room)
+}
+
+func (s *flakyStore) Remove(room, id string) error {
+	s.calls++
+	if s.fail {
+		return errFlaky
+	}
+	return s.memoryStore.Remove(room, id)
+}
+
+func TestResilientStoreHoldsAppends(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxPending int
+		// failing says whether the store is down for each append.
+		failing []bool
+		want    []string
+	}{
+		{"healthy", 10, []bool{false, false, false}, []string{"0", "1", "2", "last"}},
+		{"one failure is retried in order", 10, []bool{false, true, false}, []string{"0", "1", "2", "last"}},
+		{"full buffer drops the oldest", 2, []bool{true, true, true}, []string{"1", "2", "last"}},
+		{"no buffer", 0, []bool{true, true}, []string{"last"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := newFlakyStore()
+			s := newResilientStore(inner, tt.maxPending)
+			for i, fail := range tt.failing {
+				inner.fail = fail
+				s.downUntil = time.Time{} // try the store every time
+				err := s.Append("general", Message{ID: fmt.Sprint(i)})
+				if (err != nil) != fail {
+					t.Fatalf("append %d: err = %v, want failure %v", i, err, fail)
+				}
+			}
+			inner.fail = false
+			s.downUntil = time.Time{}
+			if err := s.Append("general", Message{ID: "last"}); err != nil {
+				t.Fatal(err)
+			}
+
+			stored, _ := inner.memoryStore.Recent("general")
+			var ids []string
+			for _, m := range stored {
+				ids = append(ids, m.ID)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("stored %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestResilientStoreFailsFastWhileDown(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(*resilientStore) error
+	}{
+		{"append", func(s *resilientStore) error { return s.Append("general", Message{ID: "m"}) }},
+		{"recent", func(s *resilientStore) error { _, err := s.Recent("general"); return err }},
+		{"remove", func(s *resilientStore) error { return s.Remove("general", "m") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := newFlakyStore()
+			inner.fail = true
+			s := newResilientStore(inner, 10)
+			if err := tt.call(s); !errors.Is(err, errFlaky) {
+				t.Fatalf("first call = %v, want the store's error", err)
+			}
+			calls := inner.calls
+			if err := tt.call(s); !errors.Is(err, errStorageDown) {
+				t.Errorf("call during backoff = %v, want errStorageDown", err)
+			}
+			if inner.calls != calls {
+				t.Error("the store was called during the backoff")
+			}
+		})
+	}
+}
+
+// stuckStore hangs every call until release is closed, like a store that
+// has stopped answering without failing.
+type stuckStore struct {
+	MessageStore
+	release chan struct{}
+}
+
+func (s *stuckStore) Append(room string, m Message) error {
+	<-s.release
+	return s.MessageStore.Append(room, m)
+}
+
+func (s *stuckStore) Recent(room string) ([]Message, error) {
+	<-s.release
+	return s.MessageStore.Recent(room)
+}
+
+// TestHubDoesNotWaitOnStore hangs the store and checks chat carries on, and
+// that a client joining meanwhile gets its backlog once the store answers,
+// ahead of what was said while it waited.
+func TestHubDoesNotWaitOnStore(t *testing.T) {
+	tests := []struct {
+		name string
+		join bool
+	}{
+		{"chat", false},
+		{"join", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.RoomRate = 0
+			ts := startServer(t, cfg)
+			h := ts.srv.hub
+			alice := ts.dial(t, user("alice"))
+			alice.await("alice's presence", isPresence("alice"))
+			bob := ts.dial(t, user("bob"))
+			alice.await("bob joining", isPresence("alice", "bob"))
+			alice.send(Message{Content: "before"})
+			bob.await("the first message", isChat("before"))
+
+			stuck := &stuckStore{release: make(chan struct{})}
+			h.readStore(func(s MessageStore) {
+				stuck.MessageStore = s
+				h.store = stuck
+			})
+			release := sync.OnceFunc(func() { close(stuck.release) })
+			t.Cleanup(release)
+
+			alice.send(Message{Content: "during"})
+			bob.await("a message while the store hangs", isChat("during"))
+			if !tt.join {
+				return
+			}
+
+			carol := ts.dial(t, user("carol"))
+			carol.await("carol's connected message", isType(typeConnected))
+			alice.send(Message{Content: "live"})
+			bob.await("a message while carol waits", isChat("live"))
+			release()
+			var got []string
+			for len(got) == 0 || got[len(got)-1] != "live" {
+				got = append(got, carol.await("a chat message", isType(typeChat)).Content)
+			}
+			if want := []string{"before", "during", "live"}; !slices.Equal(got, want) {
+				t.Errorf("carol got %v, want %v", got, want)
+			}
+		})
+	}
+}
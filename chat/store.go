@@ -0,0 +1,152 @@
+package chat
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MessageStore keeps the recent chat history of each room, trimmed to that
+// room's history size. The hub makes every call from its storage goroutine,
+// one at a time, so a slow store delays history but never chat.
+type MessageStore interface {
+	Append(room string, m Message) error
+	// Recent returns a room's history, oldest first.
+	Recent(room string) ([]Message, error)
+	Remove(room, id string) error
+}
+
+var errNotInHistory = errors.New("message not in history")
+
+// historyPager is a store that keeps scrollback beyond what Recent returns.
+// Before returns up to n messages from before the one with ID id, or from
+// the newest if id is empty, oldest first.
+type historyPager interface {
+	Before(room, id string, n int) ([]Message, error)
+}
+
+// historySizes resolves how many messages a room keeps.
+type historySizes struct {
+	def   int
+	rooms map[string]int
+	// scrollback is how far back fetch_history can page in rooms that
+	// keep history at all.
+	scrollback int
+}
+
+func (cfg Config) historySizes() historySizes {
+	return historySizes{def: cfg.HistorySize, rooms: cfg.RoomHistorySizes, scrollback: cfg.Scrollback}
+}
+
+func (s historySizes) forRoom(room string) int {
+	size := s.def
+	if n, ok := s.rooms[room]; ok {
+		size = n
+	}
+	// Replay must fit in a fresh client's send buffer.
+	return max(0, min(size, sendBufferSize))
+}
+
+// keep is how many messages a room stores: its scrollback, but never less
+// than it replays, and nothing for a room that keeps no history.
+func (s historySizes) keep(room string) int {
+	n := s.forRoom(room)
+	if n == 0 {
+		return 0
+	}
+	return max(n, s.scrollback)
+}
+
+// pageBefore picks a fetch_history page out of a room's stored messages.
+func pageBefore(messages []Message, id string, n int) ([]Message, error) {
+	if id != "" {
+		i := slices.IndexFunc(messages, func(m Message) bool { return m.ID == id })
+		if i < 0 {
+			return nil, errNotInHistory
+		}
+		messages = messages[:i]
+	}
+	return messages[max(0, len(messages)-n):], nil
+}
+
+// memoryStore is the default MessageStore: a ring buffer per room, lost on
+// restart. The storage goroutine is its only caller, but it locks anyway so
+// it stays safe to use from anywhere else.
+type memoryStore struct {
+	mu      sync.Mutex
+	sizes   historySizes
+	rooms   map[string]*history
+	inbox   inboxLimits
+	inboxes map[string][]Message
+	// known is when each user last connected, for as long as an inbox
+	// message to them could still be delivered.
+	known map[string]time.Time
+}
+
+func newMemoryStore(sizes historySizes, inbox inboxLimits) *memoryStore {
+	return &memoryStore{sizes: sizes, rooms: make(map[string]*history), inbox: inbox, inboxes: make(map[string][]Message), known: make(map[string]time.Time)}
+}
+
+func (s *memoryStore) room(name string) *history {
+	h, ok := s.rooms[name]
+	if !ok {
+		h = newHistory(s.sizes.keep(name))
+		s.rooms[name] = h
+	}
+	return h
+}
+
+func (s *memoryStore) Append(room string, m Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.room(room).add(m)
+	return nil
+}
+
+// Recent doesn't create a ring for a room with no history, so looking up
+// arbitrary room names can't grow the store.
+func (s *memoryStore) Recent(room string) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.rooms[room]
+	if !ok {
+		return []Message{}, nil
+	}
+	messages := h.messages()
+	return messages[max(0, len(messages)-s.sizes.forRoom(room)):], nil
+}
+
+func (s *memoryStore) Before(room, id string, n int) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.rooms[room]
+	if !ok {
+		return pageBefore(nil, id, n)
+	}
+	return pageBefore(h.messages(), id, n)
+}
+
+func (s *memoryStore) Remove(room, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.rooms[room]; ok {
+		h.remove(id)
+	}
+	return nil
+}
+
+// Rooms lists the rooms with history kept in memory.
+func (s *memoryStore) Rooms() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Collect(maps.Keys(s.rooms)), nil
+}
+
+// Forget drops an evicted room's history.
+func (s *memoryStore) Forget(room string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rooms, room)
+}
@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMemoryStoreRecent(t *testing.T) {
+	sizes := historySizes{def: 3, rooms: map[string]int{"quiet": 0, "big": 5}}
+	tests := []struct {
+		room   string
+		append int
+		want   int
+	}{
+		{"general", 2, 2},
+		{"general", 10, 3},
+		{"quiet", 4, 0},
+		{"big", 10, 5},
+		{"unknown", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.room, tt.append), func(t *testing.T) {
+			s := newMemoryStore(sizes, inboxLimits{})
+			for i := range tt.append {
+				s.Append(tt.room, Message{ID: fmt.Sprint(i)})
+			}
+			got, err := s.Recent(tt.room)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.want {
+				t.Fatalf("got %d messages, want %d", len(got), tt.want)
+			}
+			if tt.want > 0 && got[len(got)-1].ID != fmt.Sprint(tt.append-1) {
+				t.Errorf("newest message is %s, want %d", got[len(got)-1].ID, tt.append-1)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreRecentUnknownRoomsDontGrow(t *testing.T) {
+	s := newMemoryStore(historySizes{def: 50}, inboxLimits{})
+	for i := range 1000 {
+		got, err := s.Recent(fmt.Sprintf("room-%d", i))
+		if err != nil || len(got) != 0 {
+			t.Fatalf("Recent = %v, %v; want no messages", got, err)
+		}
+	}
+	if len(s.rooms) != 0 {
+		t.Errorf("store holds %d rooms after only reads, want 0", len(s.rooms))
+	}
+}
+
+// TestOnlyChatIsStored sends one message of each kind and checks that only
+// chat messages end up in the room's history.
+func TestOnlyChatIsStored(t *testing.T) {
+	tests := []struct {
+		name  string
+		send  *Message
+		reply string
+		want  int
+	}{
+		{"presence", nil, typePresence, 0},
+		{"chat", &Message{Content: "hello"}, typeChat, 1},
+		{"whoami", &Message{Type: typeWhoami}, typeWhoami, 0},
+		{"error", &Message{Content: "   "}, typeError, 0},
+		{"direct to someone offline", &Message{Type: typeDirect, To: "bob", Content: "psst"}, typeSystem, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := startServer(t, DefaultConfig())
+			alice := ts.dial(t, user("alice"))
+			if tt.send != nil {
+				alice.await("alice's presence", isPresence("alice"))
+				alice.send(*tt.send)
+			}
+			alice.await("the reply", isType(tt.reply))
+
+			var stored []Message
+			ts.srv.hub.readStore(func(s MessageStore) { stored, _ = s.Recent(defaultRoom) })
+			if len(stored) != tt.want {
+				t.Fatalf("stored %d messages, want %d: %+v", len(stored), tt.want, stored)
+			}
+			for _, m := range stored {
+				if m.Type != typeChat {
+					t.Errorf("stored a %s message", m.Type)
+				}
+			}
+		})
+	}
+}
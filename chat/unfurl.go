@@ -0,0 +1,256 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	unfurlTimeout   = 5 * time.Second
+	unfurlMaxBytes  = 256 << 10
+	unfurlMaxField  = 300
+	unfurlQueueSize = 64
+)
+
+var (
+	linkPattern  = regexp.MustCompile(`https?://[^\s<>"]+`)
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	metaPattern  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrPattern  = regexp.MustCompile(`(?is)(property|name|content)\s*=\s*("[^"]*"|'[^']*')`)
+
+	errUnfurlBlocked = errors.New("address not allowed for link previews")
+)
+
+// preview is what an unfurl event carries about a link.
+type preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"siteName,omitempty"`
+}
+
+// unfurler fetches link previews off the hub's goroutine: Run() only ever
+// queues a message, dropping it if the queue is full, so a slow or hostile
+// site can never hold up a broadcast.
+type unfurler struct {
+	hub    *Hub
+	queue  chan Message
+	client *http.Client
+}
+
+func newUnfurler(hub *Hub) *unfurler {
+	dialer := &net.Dialer{
+		Timeout: unfurlTimeout,
+		// Checked on the address actually dialed, after DNS, so a
+		// public name pointing at an internal address is still refused.
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil || !publicAddr(ap.Addr()) {
+				return errUnfurlBlocked
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   unfurlTimeout,
+		ResponseHeaderTimeout: unfurlTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	u := &unfurler{hub: hub, queue: make(chan Message, unfurlQueueSize)}
+	u.client = &http.Client{
+		Transport: transport,
+		Timeout:   unfurlTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			return u.checkURL(req.URL)
+		},
+	}
+	return u
+}
+
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !addr.IsLoopback() && !addr.IsLinkLocalUnicast()
+}
+
+func hostListed(host string, list []string) bool {
+	for _, h := range list {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURL refuses links that aren't plain web pages or whose host the
+// hub's UnfurlAllow and UnfurlDeny lists rule out.
+func (u *unfurler) checkURL(link *url.URL) error {
+	host := strings.ToLower(link.Hostname())
+	if link.Scheme != "http" && link.Scheme != "https" || host == "" {
+		return errUnfurlBlocked
+	}
+	allow, deny := u.hub.cfg.UnfurlAllow, u.hub.cfg.UnfurlDeny
+	if hostListed(host, deny) || len(allow) > 0 && !hostListed(host, allow) {
+		return errUnfurlBlocked
+	}
+	return nil
+}
+
+// enqueue hands a room message over for unfurling if it has a link. It
+// must only be called from Run(), and never blocks.
+func (u *unfurler) enqueue(m Message) {
+	if u == nil || m.Room == "" || !linkPattern.MatchString(m.Content) {
+		return
+	}
+	select {
+	case u.queue <- m:
+	default:
+		u.hub.debugf("Unfurl queue full, skipping links in %s", m.ID)
+	}
+}
+
+// run fetches previews at no more than rate a second until ctx is done.
+func (u *unfurler) run(ctx context.Context, rate float64) {
+	interval := time.Duration(float64(time.Second) / max(rate, 0.001))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var m Message
+		select {
+		case m = <-u.queue:
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		// Only the first link is previewed, like most chat clients do.
+		link := strings.TrimRight(linkPattern.FindString(m.Content), ".,;:!?)]}'")
+		p, err := u.fetch(ctx, link)
+		if err != nil {
+			u.hub.debugf("No preview for %s in %s: %v", link, m.ID, err)
+			continue
+		}
+		event := Message{
+			Type:      typeUnfurl,
+			ID:        m.ID,
+			Username:  systemUsername,
+			Room:      m.Room,
+			Preview:   p,
+			Timestamp: time.Now(),
+		}
+		u.hub.do(func() {
+			if r, ok := u.hub.rooms[m.Room]; ok {
+				u.hub.deliver(r, event)
+			}
+		})
+	}
+}
+
+func (u *unfurler) fetch(ctx context.Context, link string) (*preview, error) {
+	target, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.checkURL(target); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "simple-chat-unfurler/1.0")
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt != "text/html" {
+		return nil, fmt.Errorf("content type %q", mt)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, unfurlMaxBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	p := parsePreview(string(body))
+	if p.Title == "" && p.Description == "" {
+		return nil, errors.New("page has no title")
+	}
+	p.URL = link
+	return p, nil
+}
+
+// parsePreview pulls the title and OpenGraph tags out of a page. It is
+// deliberately simple; pages it can't read just get no preview.
+func parsePreview(page string) *preview {
+	p := &preview{}
+	for _, tag := range metaPattern.FindAllString(page, -1) {
+		var key, content string
+		for _, a := range attrPattern.FindAllStringSubmatch(tag, -1) {
+			value := a[2][1 : len(a[2])-1]
+			if strings.EqualFold(a[1], "content") {
+				content = value
+			} else {
+				key = strings.ToLower(value)
+			}
+		}
+		switch key {
+		case "og:title":
+			p.Title = content
+		case "og:description", "description":
+			if p.Description == "" || key == "og:description" {
+				p.Description = content
+			}
+		case "og:image":
+			if p.Image == "" {
+				p.Image = content
+			}
+		case "og:site_name":
+			p.SiteName = content
+		}
+	}
+	if p.Title == "" {
+		if m := titlePattern.FindStringSubmatch(page); m != nil {
+			p.Title = m[1]
+		}
+	}
+	p.Title = cleanPreviewText(p.Title)
+	p.Description = cleanPreviewText(p.Description)
+	p.SiteName = cleanPreviewText(p.SiteName)
+	if img, err := url.Parse(p.Image); err != nil || img.Scheme != "https" {
+		p.Image = ""
+	}
+	return p
+}
+
+func cleanPreviewText(s string) string {
+	s = strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+	if r := []rune(s); len(r) > unfurlMaxField {
+		s = string(r[:unfurlMaxField]) + "…"
+	}
+	return s
+}
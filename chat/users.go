@@ -0,0 +1,73 @@
+package chat
+
+import (
+	"slices"
+)
+
+// addUserConn and removeUserConn keep h.users, the connections grouped by
+// username, in step with h.clients. They must only be called from Run().
+func (h *Hub) addUserConn(client *Client) {
+	h.users[client.username] = append(h.users[client.username], client)
+}
+
+func (h *Hub) removeUserConn(client *Client) {
+	conns := slices.DeleteFunc(h.users[client.username], func(c *Client) bool {
+		return c == client
+	})
+	if len(conns) == 0 {
+		delete(h.users, client.username)
+		return
+	}
+	h.users[client.username] = conns
+}
+
+// sendToUser delivers a message to every connection of a user and reports
+// whether they had any.
+func (h *Hub) sendToUser(username string, message Message) bool {
+	conns := h.users[username]
+	// sendTo can drop a slow client, which edits h.users underneath us.
+	for _, client := range slices.Clone(conns) {
+		h.sendTo(client, message)
+	}
+	return len(conns) > 0
+}
+
+// deliverDirect routes a direct message to all of the recipient's
+// connections and echoes it to all of the sender's, so every tab sees the
+// conversation.
+func (h *Hub) deliverDirect(message Message) {
+	var delivered bool
+	for _, client := range slices.Clone(h.users[message.To]) {
+		delivered = true
+		if !client.muted[message.Username] {
+			h.sendTo(client, message)
+		}
+	}
+	if !delivered {
+		if o, ok := h.outboxes[message.To]; ok {
+			o.add(message, h.outboxSize)
+			delivered = true
+		}
+	}
+	if !delivered {
+		// The sender hears whether it was kept once the store answers.
+		h.storeInbox(message, func(kept bool) {
+			if !kept {
+				h.sendToUser(message.Username, systemMessage(typeSystem, "%s is offline", message.To))
+				return
+			}
+			h.sendToUser(message.Username, systemMessage(typeSystem, "%s is offline and will get your message when they next connect", message.To))
+			h.echoDirect(message)
+		})
+		return
+	}
+	h.echoDirect(message)
+}
+
+// echoDirect shows the sender's other connections a direct message they
+// sent.
+func (h *Hub) echoDirect(message Message) {
+	if message.To != message.Username {
+		h.sendToUser(message.Username, message)
+	}
+}
@@ -0,0 +1,153 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxClientMsgID bounds the opaque ID a client may attach to a message.
+const maxClientMsgID = 64
+
+// maxMutes bounds how many users one connection can mute, since each mute
+// is kept in memory for the life of the connection.
+const maxMutes = 100
+
+const (
+	emptyContentReject = "reject"
+	emptyContentAllow  = "allow"
+)
+
+// blank reports whether content would show up as an empty bubble.
+func blank(content string) bool {
+	return strings.TrimFunc(content, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.Is(unicode.Cf, r)
+	}) == ""
+}
+
+// checkContent is the problem with a chat or direct message's content, if
+// there is one. Content that is empty outright is always rejected; blank
+// content only under the reject policy.
+func (h *Hub) checkContent(content string) string {
+	switch {
+	case content == "":
+		return "content is required"
+	case h.cfg.EmptyContent == emptyContentReject && blank(content):
+		return "content must not be blank"
+	}
+	return ""
+}
+
+// checkMetadataSize rejects a message whose fields other than content
+// serialize to more than MaxMetadataBytes.
+func (h *Hub) checkMetadataSize(m Message) error {
+	if h.cfg.MaxMetadataBytes <= 0 {
+		return nil
+	}
+	m.Content = ""
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if len(data) > h.cfg.MaxMetadataBytes {
+		return wrapf(errMessageTooLong, "message metadata is %d bytes, the limit is %d", len(data), h.cfg.MaxMetadataBytes)
+	}
+	return nil
+}
+
+// clientTypes are the message types a client may send. An empty type means
+// chat.
+var clientTypes = map[string]bool{
+	"":         true,
+	typeChat:   true,
+	typeDirect: true,
+	typeWhoami: true,
+	typeMute:   true,
+	typeUnmute: true,
+	typePin:    true,
+	typeUnpin:  true,
+
+	typeFetchHistory: true,
+}
+
+// controlTypes are answered privately by the hub rather than broadcast.
+var controlTypes = map[string]bool{
+	typeWhoami: true,
+	typeMute:   true,
+	typeUnmute: true,
+	typePin:    true,
+	typeUnpin:  true,
+
+	typeFetchHistory: true,
+}
+
+// validationError lists everything wrong with a message so client developers
+// can fix it in one go.
+type validationError struct {
+	problems []string
+}
+
+func (e *validationError) Error() string {
+	return "invalid message: " + strings.Join(e.problems, "; ")
+}
+
+// validate checks an incoming message before readPump acts on it.
+func (h *Hub) validate(m Message) error {
+	var problems []string
+
+	if !clientTypes[m.Type] {
+		problems = append(problems, fmt.Sprintf("unknown type %q", m.Type))
+	}
+	switch m.Type {
+	case "", typeChat:
+		if p := h.checkContent(m.Content); p != "" {
+			problems = append(problems, p)
+		}
+	case typeMute, typeUnmute:
+		if m.Target == "" {
+			problems = append(problems, "target is required for "+m.Type)
+		} else if len(m.Target) > maxUsernameBytes {
+			problems = append(problems, fmt.Sprintf("target must be at most %d bytes", maxUsernameBytes))
+		}
+	case typePin, typeUnpin:
+		if m.ID == "" {
+			problems = append(problems, "id is required for "+m.Type)
+		}
+	case typeDirect:
+		if m.To == "" {
+			problems = append(problems, "to is required for dm")
+		}
+		if p := h.checkContent(m.Content); p != "" {
+			problems = append(problems, p)
+		}
+	}
+
+	if n := utf8.RuneCountInString(m.Content); n > h.cfg.MaxMessageRunes {
+		problems = append(problems, fmt.Sprintf("content is %d characters, the limit is %d", n, h.cfg.MaxMessageRunes))
+	}
+
+	if len(m.ClientMsgID) > maxClientMsgID {
+		problems = append(problems, fmt.Sprintf("clientMsgId must be at most %d bytes", maxClientMsgID))
+	}
+
+	if m.Limit < 0 {
+		problems = append(problems, "limit must not be negative")
+	}
+
+	if !validFormats[m.Format] {
+		problems = append(problems, fmt.Sprintf("format must be %q or %q", formatPlain, formatMarkdown))
+	}
+
+	if m.TTL < 0 {
+		problems = append(problems, "ttl must not be negative")
+	} else if m.TTL > int(h.cfg.MaxTTL.Seconds()) {
+		problems = append(problems, fmt.Sprintf("ttl must be at most %d seconds", int(h.cfg.MaxTTL.Seconds())))
+	}
+
+	if len(problems) > 0 {
+		return &validationError{problems: problems}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidateBlankContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		typ     string
+		content string
+		want    string
+	}{
+		{"empty", emptyContentAllow, typeChat, "", "content is required"},
+		{"spaces", emptyContentReject, typeChat, "   ", "content must not be blank"},
+		{"newlines and tabs", emptyContentReject, typeChat, "\n\t\n", "content must not be blank"},
+		{"no-break space", emptyContentReject, typeChat, "\u00a0", "content must not be blank"},
+		{"zero-width space", emptyContentReject, typeChat, "\u200b\u200b", "content must not be blank"},
+		{"direct message", emptyContentReject, typeDirect, "  ", "content must not be blank"},
+		{"blank allowed", emptyContentAllow, typeChat, "   ", ""},
+		{"text with spaces", emptyContentReject, typeChat, "  hi  ", ""},
+		{"emoji", emptyContentReject, typeChat, "👍", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.EmptyContent = tt.policy
+			h := NewHub(cfg)
+			err := h.validate(Message{Type: tt.typ, To: "bob", Content: tt.content})
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("validate = %v, want nil", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("validate = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckMetadataSize(t *testing.T) {
+	base := Message{ID: "m1", Type: typeChat, Username: "alice", Room: "general", Color: "#336699"}
+	data, err := json.Marshal(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := len(data)
+
+	tests := []struct {
+		name    string
+		limit   int
+		content string
+		wantErr bool
+	}{
+		{"at the limit", size, "", false},
+		{"one byte over", size - 1, "", true},
+		{"content doesn't count", size, strings.Repeat("x", 10*size), false},
+		{"no limit", 0, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.MaxMetadataBytes = tt.limit
+			m := base
+			m.Content = tt.content
+			err := NewHub(cfg).checkMetadataSize(m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkMetadataSize = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errMessageTooLong) {
+				t.Errorf("error %v isn't errMessageTooLong", err)
+			}
+		})
+	}
+}
+
+func TestValidateRuneLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"ascii at the limit", "abcde", false},
+		{"ascii over", "abcdef", true},
+		{"emoji at the limit", "😀😀😀😀😀", false},
+		{"emoji over", "😀😀😀😀😀😀", true},
+		{"cjk at the limit", "你好世界们", false},
+		{"cjk over", "你好世界你们", true},
+		{"mixed at the limit", "a😀你b🎉", false},
+		// A ZWJ sequence draws as one emoji but is several runes.
+		{"zwj sequence", "👨\u200d👩\u200d👧", false},
+		{"zwj sequence over", "👨\u200d👩\u200d👧\u200d👦", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.MaxMessageRunes = 5
+			err := NewHub(cfg).validate(Message{Content: tt.content})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate(%q) = %v, want error %v", tt.content, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMuteTarget(t *testing.T) {
+	tests := []struct {
+		target  string
+		wantErr bool
+	}{
+		{"bob", false},
+		{"", true},
+		{strings.Repeat("b", maxUsernameBytes), false},
+		{strings.Repeat("b", maxUsernameBytes+1), true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d bytes", len(tt.target)), func(t *testing.T) {
+			err := NewHub(DefaultConfig()).validate(Message{Type: typeMute, Target: tt.target})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package chat
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// wireMessage is the JSON protocol spoken to clients, the REST API and the
+// stores. It is the one place field names are defined: Message can be
+// reshaped freely as long as toWire and message still map it onto these
+// names. Fields may be added here; renaming or removing one breaks clients
+// in the wild.
+type wireMessage struct {
+	ID           string        `json:"id,omitempty"`
+	Type         string        `json:"type,omitempty"`
+	Username     string        `json:"username"`
+	Room         string        `json:"room,omitempty"`
+	To           string        `json:"to,omitempty"`
+	Target       string        `json:"target,omitempty"`
+	Priority     bool          `json:"priority,omitempty"`
+	ConnID       string        `json:"connId,omitempty"`
+	Content      string        `json:"content"`
+	Timestamp    time.Time     `json:"timestamp"`
+	Platform     string        `json:"platform,omitempty"`
+	Online       []string      `json:"online,omitempty"`
+	Mentions     []string      `json:"mentions,omitempty"`
+	TTL          int           `json:"ttl,omitempty"`
+	ExpiresAt    time.Time     `json:"expiresAt,omitzero"`
+	RTTMillis    int64         `json:"rttMs,omitempty"`
+	Color        string        `json:"color,omitempty"`
+	AvatarURL    string        `json:"avatarUrl,omitempty"`
+	Format       string        `json:"format,omitempty"`
+	Capabilities *capabilities `json:"capabilities,omitempty"`
+	Before       string        `json:"before,omitempty"`
+	Limit        int           `json:"limit,omitempty"`
+	History      []Message     `json:"history,omitempty"`
+	Verified     bool          `json:"verified,omitempty"`
+	Sealed       bool          `json:"sealed,omitempty"`
+	ClientMsgID  string        `json:"clientMsgId,omitempty"`
+	Preview      *preview      `json:"preview,omitempty"`
+	DisplayTime  string        `json:"displayTime,omitempty"`
+}
+
+func (m Message) toWire() wireMessage {
+	return wireMessage{
+		ID:           m.ID,
+		Type:         m.Type,
+		Username:     m.Username,
+		Room:         m.Room,
+		To:           m.To,
+		Target:       m.Target,
+		Priority:     m.Priority,
+		ConnID:       m.ConnID,
+		Content:      m.Content,
+		Timestamp:    m.Timestamp,
+		Platform:     m.Platform,
+		Online:       m.Online,
+		Mentions:     m.Mentions,
+		TTL:          m.TTL,
+		ExpiresAt:    m.ExpiresAt,
+		RTTMillis:    m.RTTMillis,
+		Color:        m.Color,
+		AvatarURL:    m.AvatarURL,
+		Format:       m.Format,
+		Capabilities: m.Capabilities,
+		Before:       m.Before,
+		Limit:        m.Limit,
+		History:      m.History,
+		Verified:     m.Verified,
+		Sealed:       m.Sealed,
+		ClientMsgID:  m.ClientMsgID,
+		Preview:      m.Preview,
+		DisplayTime:  m.DisplayTime,
+	}
+}
+
+func (w wireMessage) message() Message {
+	return Message{
+		ID:           w.ID,
+		Type:         w.Type,
+		Username:     w.Username,
+		Room:         w.Room,
+		To:           w.To,
+		Target:       w.Target,
+		Priority:     w.Priority,
+		ConnID:       w.ConnID,
+		Content:      w.Content,
+		Timestamp:    w.Timestamp,
+		Platform:     w.Platform,
+		Online:       w.Online,
+		Mentions:     w.Mentions,
+		TTL:          w.TTL,
+		ExpiresAt:    w.ExpiresAt,
+		RTTMillis:    w.RTTMillis,
+		Color:        w.Color,
+		AvatarURL:    w.AvatarURL,
+		Format:       w.Format,
+		Capabilities: w.Capabilities,
+		Before:       w.Before,
+		Limit:        w.Limit,
+		History:      w.History,
+		Verified:     w.Verified,
+		Sealed:       w.Sealed,
+		ClientMsgID:  w.ClientMsgID,
+		Preview:      w.Preview,
+		// DisplayTime is only ever sent, so a client can't forge one
+		// for others to see.
+	}
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.toWire())
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	w := m.toWire()
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	*m = w.message()
+	return nil
+}
@@ -0,0 +1,81 @@
+package chat
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestWireShape pins the JSON clients see. A failure here means a change
+// that breaks clients in the wild.
+func TestWireShape(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		m    Message
+		want string
+	}{
+		{
+			"chat",
+			Message{ID: "m1", Type: typeChat, Username: "alice", Room: "general", ConnID: "c1", Content: "hi", Timestamp: ts, Platform: "web"},
+			`{"id":"m1","type":"chat","username":"alice","room":"general","connId":"c1","content":"hi","timestamp":"2024-05-01T12:00:00Z","platform":"web"}`,
+		},
+		{
+			"direct",
+			Message{ID: "m2", Type: typeDirect, Username: "alice", To: "bob", Content: "psst", Timestamp: ts},
+			`{"id":"m2","type":"dm","username":"alice","to":"bob","content":"psst","timestamp":"2024-05-01T12:00:00Z"}`,
+		},
+		{
+			"presence",
+			Message{Type: typePresence, Username: systemUsername, Room: "general", Online: []string{"alice", "bob"}, Timestamp: ts},
+			`{"type":"presence","username":"` + systemUsername + `","room":"general","content":"","timestamp":"2024-05-01T12:00:00Z","online":["alice","bob"]}`,
+		},
+		{
+			"ephemeral",
+			Message{ID: "m3", Type: typeChat, Username: "alice", Content: "soon gone", Timestamp: ts, TTL: 60, ExpiresAt: ts.Add(time.Minute)},
+			`{"id":"m3","type":"chat","username":"alice","content":"soon gone","timestamp":"2024-05-01T12:00:00Z","ttl":60,"expiresAt":"2024-05-01T12:01:00Z"}`,
+		},
+		{
+			"latency",
+			Message{Type: typeLatency, Username: systemUsername, RTTMillis: 42, Timestamp: ts},
+			`{"type":"latency","username":"` + systemUsername + `","content":"","timestamp":"2024-05-01T12:00:00Z","rttMs":42}`,
+		},
+		{
+			"internal fields stay out",
+			Message{Type: typeChat, Username: "alice", Timestamp: ts, received: ts, text: &localText{format: "x"}},
+			`{"type":"chat","username":"alice","content":"","timestamp":"2024-05-01T12:00:00Z"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("got  %s\nwant %s", data, tt.want)
+			}
+
+			var back Message
+			if err := json.Unmarshal(data, &back); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.m
+			want.received, want.text = time.Time{}, nil
+			if !reflect.DeepEqual(back, want) {
+				t.Errorf("round trip = %+v, want %+v", back, want)
+			}
+		})
+	}
+}
+
+func TestWireDisplayTimeIsOutgoingOnly(t *testing.T) {
+	var m Message
+	if err := json.Unmarshal([]byte(`{"content":"hi","displayTime":"forged"}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.DisplayTime != "" {
+		t.Errorf("DisplayTime = %q, want it ignored", m.DisplayTime)
+	}
+}